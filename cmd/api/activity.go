@@ -0,0 +1,36 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// lastUsedTracker remembers when credentials (session tokens, api keys) were
+// last used so authenticate doesn't have to write to the db on every request
+// the times are drained and written in one batch by a background job
+type lastUsedTracker[K comparable] struct {
+	mu       sync.Mutex
+	lastUsed map[K]time.Time
+}
+
+func newLastUsedTracker[K comparable]() *lastUsedTracker[K] {
+	return &lastUsedTracker[K]{lastUsed: make(map[K]time.Time)}
+}
+
+func (t *lastUsedTracker[K]) touch(key K) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.lastUsed[key] = time.Now()
+}
+
+// returns everything recorded since the last drain and starts afresh
+func (t *lastUsedTracker[K]) drain() map[K]time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	lastUsed := t.lastUsed
+	t.lastUsed = make(map[K]time.Time)
+
+	return lastUsed
+}
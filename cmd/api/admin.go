@@ -0,0 +1,575 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/V4N1LLA-1CE/movie-db-api/internal/data"
+	"github.com/V4N1LLA-1CE/movie-db-api/internal/validator"
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+)
+
+// admin endpoints for managing roles and who holds which permissions
+// all of them require the 'users:manage' permission
+
+// GET /v1/admin/roles
+func (app *application) listRolesHandler(w http.ResponseWriter, r *http.Request) {
+	roles, err := app.models.Roles.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	permissions, err := app.models.Permissions.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"roles": roles, "permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// POST /v1/admin/roles
+func (app *application) createRoleHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name        string   `json:"name"`
+		Description string   `json:"description"`
+		Permissions []string `json:"permissions"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	known, err := app.models.Permissions.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	role := &data.Role{
+		Name:        input.Name,
+		Description: input.Description,
+		Permissions: input.Permissions,
+	}
+
+	v := validator.New()
+
+	if data.ValidateRole(v, role, known); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Roles.Insert(role)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateRole):
+			v.AddError("name", "a role with this name already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"role": role}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// GET /v1/admin/users/:id/grants
+func (app *application) showUserGrantsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readAdminUser(w, r)
+	if !ok {
+		return
+	}
+
+	app.writeUserGrants(w, r, user)
+}
+
+// PUT /v1/admin/users/:id/roles/:name
+func (app *application) assignRoleHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readAdminUser(w, r)
+	if !ok {
+		return
+	}
+
+	name := httprouter.ParamsFromContext(r.Context()).ByName("name")
+
+	err := app.models.Roles.AddForUser(user.ID, name)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.recordSecurityEvent(r, data.EventRoleAssigned, data.OutcomeSuccess, user.ID, map[string]string{"role": name})
+
+	app.permissionCache.invalidate(user.ID)
+
+	app.writeUserGrants(w, r, user)
+}
+
+// DELETE /v1/admin/users/:id/roles/:name
+func (app *application) revokeRoleHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readAdminUser(w, r)
+	if !ok {
+		return
+	}
+
+	name := httprouter.ParamsFromContext(r.Context()).ByName("name")
+
+	err := app.models.Roles.DeleteForUser(user.ID, name)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.recordSecurityEvent(r, data.EventRoleRevoked, data.OutcomeSuccess, user.ID, map[string]string{"role": name})
+
+	app.permissionCache.invalidate(user.ID)
+
+	app.writeUserGrants(w, r, user)
+}
+
+// PUT /v1/admin/users/:id/permissions/:code
+func (app *application) grantPermissionHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readAdminUser(w, r)
+	if !ok {
+		return
+	}
+
+	code := httprouter.ParamsFromContext(r.Context()).ByName("code")
+
+	known, err := app.models.Permissions.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !known.Include(code) {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Permissions.AddForUser(user.ID, code)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.recordSecurityEvent(r, data.EventPermissionGranted, data.OutcomeSuccess, user.ID, map[string]string{"permission": code})
+
+	app.permissionCache.invalidate(user.ID)
+
+	app.writeUserGrants(w, r, user)
+}
+
+// DELETE /v1/admin/users/:id/permissions/:code
+// only removes a direct grant, the user keeps the permission if one of their roles has it
+func (app *application) revokePermissionHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readAdminUser(w, r)
+	if !ok {
+		return
+	}
+
+	code := httprouter.ParamsFromContext(r.Context()).ByName("code")
+
+	err := app.models.Permissions.DeleteForUser(user.ID, code)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.recordSecurityEvent(r, data.EventPermissionRevoked, data.OutcomeSuccess, user.ID, map[string]string{"permission": code})
+
+	app.permissionCache.invalidate(user.ID)
+
+	app.writeUserGrants(w, r, user)
+}
+
+// GET /v1/admin/grants?permission=movies:write or ?role=editor
+// lists who holds a permission or role
+func (app *application) listGrantsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Permission string
+		Role       string
+		Filters    data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Permission = app.readString(qs, "permission", "")
+	input.Role = app.readString(qs, "role", "")
+
+	v.Check(input.Permission != "" || input.Role != "", "permission", "either permission or role must be provided")
+	v.Check(input.Permission == "" || input.Role == "", "permission", "only one of permission or role can be provided")
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafeList = []string{"id", "email", "-id", "-email"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	var grantees []*data.Grantee
+	var metadata data.Metadata
+	var err error
+
+	if input.Permission != "" {
+		grantees, metadata, err = app.models.Permissions.GetHolders(input.Permission, input.Filters)
+	} else {
+		grantees, metadata, err = app.models.Roles.GetHolders(input.Role, input.Filters)
+	}
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"grants": grantees, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// GET /v1/admin/users
+// search with ?email=, ?activated=, ?deactivated=, ?created_after= and ?created_before=
+func (app *application) listUsersHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Search  data.UserSearch
+		Filters data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Search.Email = app.readString(qs, "email", "")
+	input.Search.Activated = app.readBool(qs, "activated", v)
+	input.Search.Deactivated = app.readBool(qs, "deactivated", v)
+	input.Search.CreatedAfter = app.readTime(qs, "created_after", v)
+	input.Search.CreatedBefore = app.readTime(qs, "created_before", v)
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafeList = []string{"id", "email", "created_at", "-id", "-email", "-created_at"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	users, metadata, err := app.models.Users.GetAll(input.Search, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"users": users, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// GET /v1/admin/users/:id
+// the user along with their permissions, active sessions and api keys
+func (app *application) showUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readAdminUser(w, r)
+	if !ok {
+		return
+	}
+
+	roles, err := app.models.Roles.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	slices.Sort(permissions)
+
+	sessions, err := app.models.Tokens.GetSessionsForUser(user.ID, "", uuid.Nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	keys, err := app.models.APIKeys.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{
+		"user":        user,
+		"roles":       roles,
+		"permissions": permissions,
+		"sessions":    sessions,
+		"api_keys":    keys,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// PUT /v1/admin/users/:id/activated
+// activates the account without the email confirmation and lifts any deactivation
+func (app *application) adminActivateUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readAdminUser(w, r)
+	if !ok {
+		return
+	}
+
+	user.Activated = true
+	user.DeactivatedAt = nil
+
+	if !app.updateAdminUser(w, r, user) {
+		return
+	}
+
+	// activation links that were sent out are no use anymore
+	err := app.models.Tokens.DeleteAllForUser(data.ScopeActivation, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.recordSecurityEvent(r, data.EventUserActivated, data.OutcomeSuccess, user.ID, nil)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// PUT /v1/admin/users/:id/deactivated
+// locks the user out until they're activated again, every token they hold is revoked
+// and their api keys stop working
+func (app *application) adminDeactivateUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readAdminUser(w, r)
+	if !ok {
+		return
+	}
+
+	// stop admins from locking themselves out
+	if user.ID == app.contextGetUser(r).ID {
+		v := validator.New()
+		v.AddError("id", "you can't deactivate your own account")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if !user.IsDeactivated() {
+		now := time.Now()
+		user.DeactivatedAt = &now
+
+		if !app.updateAdminUser(w, r, user) {
+			return
+		}
+	}
+
+	// revoked sessions go on the deny-list, so signed access tokens stop working too
+	err := app.models.Tokens.DeleteAllScopesForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.APIKeys.DeleteAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.recordSecurityEvent(r, data.EventUserDeactivated, data.OutcomeSuccess, user.ID, nil)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// POST /v1/admin/users/:id/password-reset
+// the current password stops working, the user is logged out everywhere and
+// emailed a link to choose a new one
+func (app *application) adminForcePasswordResetHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readAdminUser(w, r)
+	if !ok {
+		return
+	}
+
+	err := user.Password.Scramble()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !app.updateAdminUser(w, r, user) {
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllSessionsForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(data.ScopePasswordReset, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopePasswordReset)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.background(func() {
+		data := map[string]any{
+			"passwordResetToken": token.Plaintext,
+		}
+
+		err := app.mailer.Send(user.Email, "token_password_reset_forced.tmpl", data)
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+	})
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"message": "the user's password was reset and a link to set a new one was sent to them"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// DELETE /v1/admin/users/:id/sessions
+func (app *application) adminDeleteUserSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readAdminUser(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Tokens.DeleteAllSessionsForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "all of the user's sessions were revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// looks up the user from the :id param, writing a 404 if they don't exist
+func (app *application) readAdminUser(w http.ResponseWriter, r *http.Request) (*data.User, bool) {
+	id, err := app.readIdParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	user, err := app.models.Users.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return user, true
+}
+
+// writes the response itself and reports false if the update failed
+func (app *application) updateAdminUser(w http.ResponseWriter, r *http.Request, user *data.User) bool {
+	err := app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrUpdateConflict):
+			app.updateConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return false
+	}
+
+	return true
+}
+
+// responds with the user's roles, direct permissions and the effective set
+// changes only show up in signed access tokens once they're refreshed
+func (app *application) writeUserGrants(w http.ResponseWriter, r *http.Request, user *data.User) {
+	roles, err := app.models.Roles.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	direct, err := app.models.Permissions.GetDirectForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	effective, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	slices.Sort(effective)
+
+	env := envelope{
+		"user_id": user.ID,
+		"grants": envelope{
+			"roles":       roles,
+			"permissions": direct,
+			"effective":   effective,
+		},
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/V4N1LLA-1CE/movie-db-api/internal/data"
+	"github.com/V4N1LLA-1CE/movie-db-api/internal/validator"
+)
+
+// POST /v1/users/me/api-keys
+func (app *application) createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var input struct {
+		Name        string     `json:"name"`
+		Permissions []string   `json:"permissions"`
+		Expiry      *time.Time `json:"expiry"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	permissions, err := app.cachedPermissions(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	key := &data.APIKey{
+		Name:        input.Name,
+		Permissions: input.Permissions,
+		Expiry:      input.Expiry,
+	}
+
+	v := validator.New()
+
+	if data.ValidateAPIKey(v, key, permissions); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	key, err = app.models.APIKeys.New(user.ID, key.Name, key.Permissions, key.Expiry)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.recordSecurityEvent(r, data.EventAPIKeyCreated, data.OutcomeSuccess, user.ID, map[string]string{"api_key_id": strconv.FormatInt(key.ID, 10)})
+
+	// the plaintext key is only ever returned here
+	err = app.writeJSON(w, http.StatusCreated, envelope{"api_key": key}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// GET /v1/users/me/api-keys
+func (app *application) listAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	keys, err := app.models.APIKeys.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"api_keys": keys}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// DELETE /v1/users/me/api-keys/:id
+func (app *application) deleteAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	id, err := app.readIdParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.APIKeys.DeleteForUser(id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.recordSecurityEvent(r, data.EventAPIKeyRevoked, data.OutcomeSuccess, user.ID, map[string]string{"api_key_id": strconv.FormatInt(id, 10)})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "api key successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/V4N1LLA-1CE/movie-db-api/internal/data"
+	"github.com/V4N1LLA-1CE/movie-db-api/internal/validator"
+	"github.com/julienschmidt/httprouter"
+)
+
+// what the user behind a request can do to a movie
+type movieAccess int
+
+const (
+	movieAccessNone         movieAccess = iota
+	movieAccessCollaborator             // can edit the movie
+	movieAccessOwner                    // can also delete it and manage its collaborators
+)
+
+// movies:write can change any movie, movies:write:own only the ones the user
+// created or was added to as a collaborator
+func (app *application) movieAccess(r *http.Request, movie *data.Movie) (movieAccess, error) {
+	ok, err := app.hasPermission(r, "movies:write")
+	if err != nil {
+		return movieAccessNone, err
+	}
+
+	if ok {
+		return movieAccessOwner, nil
+	}
+
+	ok, err = app.hasPermission(r, "movies:write:own")
+	if err != nil || !ok {
+		return movieAccessNone, err
+	}
+
+	user := app.contextGetUser(r)
+
+	if movie.CreatedBy != nil && *movie.CreatedBy == user.ID {
+		return movieAccessOwner, nil
+	}
+
+	ok, err = app.models.Collaborators.Exists(movie.ID, user.ID)
+	if err != nil || !ok {
+		return movieAccessNone, err
+	}
+
+	return movieAccessCollaborator, nil
+}
+
+// reads the movie from the :id param and checks the user has at least the
+// access needed, writes the response itself and reports false if not
+func (app *application) readMovieForWrite(w http.ResponseWriter, r *http.Request, needed movieAccess) (*data.Movie, bool) {
+	id, err := app.readIdParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	movie, err := app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	access, err := app.movieAccess(r, movie)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+
+	if access < needed {
+		app.notPermittedResponse(w, r)
+		return nil, false
+	}
+
+	return movie, true
+}
+
+// GET /v1/movies/:id/collaborators
+func (app *application) listMovieCollaboratorsHandler(w http.ResponseWriter, r *http.Request) {
+	movie, ok := app.readMovieForWrite(w, r, movieAccessCollaborator)
+	if !ok {
+		return
+	}
+
+	app.writeMovieCollaborators(w, r, movie)
+}
+
+// POST /v1/movies/:id/collaborators
+// gives another user edit rights on the movie, they still need movies:write:own
+func (app *application) addMovieCollaboratorHandler(w http.ResponseWriter, r *http.Request) {
+	movie, ok := app.readMovieForWrite(w, r, movieAccessOwner)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("email", "no matching user account found")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if movie.CreatedBy != nil && *movie.CreatedBy == user.ID {
+		v.AddError("email", "belongs to the user who created the movie")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Collaborators.Insert(movie.ID, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeMovieCollaborators(w, r, movie)
+}
+
+// DELETE /v1/movies/:id/collaborators/:user_id
+func (app *application) removeMovieCollaboratorHandler(w http.ResponseWriter, r *http.Request) {
+	movie, ok := app.readMovieForWrite(w, r, movieAccessOwner)
+	if !ok {
+		return
+	}
+
+	userID, err := strconv.ParseInt(httprouter.ParamsFromContext(r.Context()).ByName("user_id"), 10, 64)
+	if err != nil || userID < 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Collaborators.Delete(movie.ID, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeMovieCollaborators(w, r, movie)
+}
+
+func (app *application) writeMovieCollaborators(w http.ResponseWriter, r *http.Request, movie *data.Movie) {
+	collaborators, err := app.models.Collaborators.GetAllForMovie(movie.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{
+		"movie_id":      movie.ID,
+		"created_by":    movie.CreatedBy,
+		"collaborators": collaborators,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
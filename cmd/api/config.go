@@ -4,7 +4,10 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
 	"time"
+
+	"github.com/V4N1LLA-1CE/movie-db-api/internal/data"
 )
 
 type config struct {
@@ -17,9 +20,11 @@ type config struct {
 		maxIdleTime  time.Duration
 	}
 	limiter struct {
-		rps     float64
-		burst   int
-		enabled bool
+		rps          float64
+		burst        int
+		enabled      bool
+		suggestRPS   float64
+		suggestBurst int
 	}
 	smtp struct {
 		host     string
@@ -31,6 +36,43 @@ type config struct {
 	cors struct {
 		trustedOrigins []string
 	}
+	accounts struct {
+		deletionGracePeriod time.Duration
+		mfaRequiredForWrite bool
+	}
+	securityEvents struct {
+		retention time.Duration
+	}
+	passwords struct {
+		hash         data.PasswordHashParams
+		breachedFile string
+	}
+	permissions struct {
+		cacheTTL time.Duration
+		listen   bool
+	}
+	login struct {
+		maxFailures      int
+		maxFailuresPerIP int
+		lockout          time.Duration
+	}
+	magicLink struct {
+		enabled bool
+		ttl     time.Duration
+	}
+	registration struct {
+		mode           string   // open, invite or domain
+		allowedDomains []string // only used in domain mode, empty allows any domain that isn't disposable
+		disposableFile string
+		invitationTTL  time.Duration
+	}
+	tokens struct {
+		accessTTL  time.Duration
+		refreshTTL time.Duration
+		signed     bool
+		keysetFile string
+		pepperFile string
+	}
 }
 
 func newConfig() config {
@@ -82,6 +124,25 @@ func newConfig() config {
 	cfg.limiter.burst = burst
 	cfg.limiter.enabled = rpsEnabled
 
+	// typeahead gets its own, more generous limiter since it's called on every keystroke
+	// optional, defaults to 20 rps with a burst of 40
+	cfg.limiter.suggestRPS = 20
+	cfg.limiter.suggestBurst = 40
+
+	if s := os.Getenv("SUGGEST_RATE_LIMIT"); s != "" {
+		cfg.limiter.suggestRPS, err = strconv.ParseFloat(s, 64)
+		if err != nil {
+			log.Fatal("failed to parse SUGGEST_RATE_LIMIT, is this float64?")
+		}
+	}
+
+	if s := os.Getenv("SUGGEST_RATE_LIMIT_BURST_SIZE"); s != "" {
+		cfg.limiter.suggestBurst, err = strconv.Atoi(s)
+		if err != nil {
+			log.Fatal("failed to parse SUGGEST_RATE_LIMIT_BURST_SIZE, is this int type?")
+		}
+	}
+
 	cfg.db.maxOpenConns = moc
 	cfg.db.maxIdleConns = mic
 	cfg.db.maxIdleTime = time.Duration(mit) * time.Minute
@@ -97,5 +158,234 @@ func newConfig() config {
 	cfg.smtp.password = os.Getenv("SMTP_PASSWORD")
 	cfg.smtp.sender = os.Getenv("SMTP_SENDER")
 
+	// how long a deleted account can still be restored before it's gone for good
+	// optional, defaults to 30 days
+	cfg.accounts.deletionGracePeriod = 30 * 24 * time.Hour
+
+	if s := os.Getenv("ACCOUNT_DELETION_GRACE_DAYS"); s != "" {
+		days, err := strconv.Atoi(s)
+		if err != nil || days < 0 {
+			log.Fatal("failed to parse ACCOUNT_DELETION_GRACE_DAYS, is this int type?")
+		}
+		cfg.accounts.deletionGracePeriod = time.Duration(days) * 24 * time.Hour
+	}
+
+	// how long security events are kept before they're purged
+	// optional, defaults to 365 days, 0 keeps them forever
+	cfg.securityEvents.retention = 365 * 24 * time.Hour
+
+	if s := os.Getenv("SECURITY_EVENT_RETENTION_DAYS"); s != "" {
+		days, err := strconv.Atoi(s)
+		if err != nil || days < 0 {
+			log.Fatal("failed to parse SECURITY_EVENT_RETENTION_DAYS, is this int type?")
+		}
+		cfg.securityEvents.retention = time.Duration(days) * 24 * time.Hour
+	}
+
+	// accounts with movies:write or movies:write:own can be made to enable two-factor authentication
+	// before they're allowed to use it
+	// optional, defaults to false
+	if s := os.Getenv("MFA_REQUIRED_FOR_WRITE"); s != "" {
+		cfg.accounts.mfaRequiredForWrite, err = strconv.ParseBool(s)
+		if err != nil {
+			log.Fatal("failed to parse MFA_REQUIRED_FOR_WRITE, is this bool type?")
+		}
+	}
+
+	// new passwords are hashed with argon2id by default, existing hashes keep
+	// working and are upgraded to the current settings when their user logs in
+	// optional, defaults to argon2id with 64 MiB, 3 iterations and 2 threads
+	cfg.passwords.hash = data.DefaultPasswordHashParams
+
+	if s := os.Getenv("PASSWORD_HASH_ALGORITHM"); s != "" {
+		cfg.passwords.hash.Algorithm = s
+	}
+
+	if s := os.Getenv("ARGON2_MEMORY_KIB"); s != "" {
+		memory, err := strconv.ParseUint(s, 10, 32)
+		if err != nil {
+			log.Fatal("failed to parse ARGON2_MEMORY_KIB, is this int type?")
+		}
+		cfg.passwords.hash.Memory = uint32(memory)
+	}
+
+	if s := os.Getenv("ARGON2_ITERATIONS"); s != "" {
+		iterations, err := strconv.ParseUint(s, 10, 32)
+		if err != nil {
+			log.Fatal("failed to parse ARGON2_ITERATIONS, is this int type?")
+		}
+		cfg.passwords.hash.Iterations = uint32(iterations)
+	}
+
+	if s := os.Getenv("ARGON2_PARALLELISM"); s != "" {
+		parallelism, err := strconv.ParseUint(s, 10, 8)
+		if err != nil {
+			log.Fatal("failed to parse ARGON2_PARALLELISM, is this int type?")
+		}
+		cfg.passwords.hash.Parallelism = uint8(parallelism)
+	}
+
+	if s := os.Getenv("BCRYPT_COST"); s != "" {
+		cfg.passwords.hash.BcryptCost, err = strconv.Atoi(s)
+		if err != nil {
+			log.Fatal("failed to parse BCRYPT_COST, is this int type?")
+		}
+	}
+
+	err = data.SetPasswordHashParams(cfg.passwords.hash)
+	if err != nil {
+		log.Fatalf("invalid password hash settings: %v", err)
+	}
+
+	// new passwords are checked against a list of common breached passwords
+	// that ships with the binary, a bigger list can be added from a local file
+	// in the format described in internal/breached
+	// optional, defaults to only the built in list
+	cfg.passwords.breachedFile = os.Getenv("BREACHED_PASSWORDS_FILE")
+
+	// failed logins first slow down then lock out the email that was tried,
+	// and separately any IP that fails a lot across different emails
+	// optional, defaults to 10 per email, 50 per IP and a 15 minute lockout
+	cfg.login.maxFailures = 10
+	cfg.login.maxFailuresPerIP = 50
+	cfg.login.lockout = 15 * time.Minute
+
+	if s := os.Getenv("LOGIN_MAX_FAILURES"); s != "" {
+		cfg.login.maxFailures, err = strconv.Atoi(s)
+		if err != nil || cfg.login.maxFailures < 1 {
+			log.Fatal("failed to parse LOGIN_MAX_FAILURES, is this int type?")
+		}
+	}
+
+	if s := os.Getenv("LOGIN_MAX_FAILURES_PER_IP"); s != "" {
+		cfg.login.maxFailuresPerIP, err = strconv.Atoi(s)
+		if err != nil || cfg.login.maxFailuresPerIP < 1 {
+			log.Fatal("failed to parse LOGIN_MAX_FAILURES_PER_IP, is this int type?")
+		}
+	}
+
+	if s := os.Getenv("LOGIN_LOCKOUT_MINUTES"); s != "" {
+		minutes, err := strconv.Atoi(s)
+		if err != nil || minutes < 1 {
+			log.Fatal("failed to parse LOGIN_LOCKOUT_MINUTES, is this int type?")
+		}
+		cfg.login.lockout = time.Duration(minutes) * time.Minute
+	}
+
+	// permissions are cached per user so they aren't queried on every request,
+	// grants changed through the admin endpoints take effect straight away,
+	// ones made straight in the db (or through another instance, unless
+	// listening for changes) take up to the ttl
+	// optional, defaults to 60 seconds, 0 disables the cache
+	cfg.permissions.cacheTTL = 60 * time.Second
+
+	if s := os.Getenv("PERMISSION_CACHE_TTL_SECONDS"); s != "" {
+		seconds, err := strconv.Atoi(s)
+		if err != nil || seconds < 0 {
+			log.Fatal("failed to parse PERMISSION_CACHE_TTL_SECONDS, is this int type?")
+		}
+		cfg.permissions.cacheTTL = time.Duration(seconds) * time.Second
+	}
+
+	// LISTEN for grant changes so every instance drops stale entries at once
+	// optional, defaults to false
+	if s := os.Getenv("PERMISSION_CACHE_LISTEN"); s != "" {
+		cfg.permissions.listen, err = strconv.ParseBool(s)
+		if err != nil {
+			log.Fatal("failed to parse PERMISSION_CACHE_LISTEN, is this bool type?")
+		}
+	}
+
+	// access tokens are kept short since they're sent on every request,
+	// refresh tokens are swapped for new ones before they run out
+	// optional, defaults to 15 minutes and 30 days
+	cfg.tokens.accessTTL = 15 * time.Minute
+	cfg.tokens.refreshTTL = 30 * 24 * time.Hour
+
+	if s := os.Getenv("ACCESS_TOKEN_TTL_MINUTES"); s != "" {
+		minutes, err := strconv.Atoi(s)
+		if err != nil || minutes < 1 {
+			log.Fatal("failed to parse ACCESS_TOKEN_TTL_MINUTES, is this int type?")
+		}
+		cfg.tokens.accessTTL = time.Duration(minutes) * time.Minute
+	}
+
+	if s := os.Getenv("REFRESH_TOKEN_TTL_DAYS"); s != "" {
+		days, err := strconv.Atoi(s)
+		if err != nil || days < 1 {
+			log.Fatal("failed to parse REFRESH_TOKEN_TTL_DAYS, is this int type?")
+		}
+		cfg.tokens.refreshTTL = time.Duration(days) * 24 * time.Hour
+	}
+
+	// passwordless login with a link emailed to the user, it proves they own
+	// the address so accounts that aren't activated yet are activated by it
+	// optional, defaults to disabled with links lasting 15 minutes
+	cfg.magicLink.ttl = 15 * time.Minute
+
+	if s := os.Getenv("MAGIC_LINK_ENABLED"); s != "" {
+		cfg.magicLink.enabled, err = strconv.ParseBool(s)
+		if err != nil {
+			log.Fatal("failed to parse MAGIC_LINK_ENABLED, is this bool type?")
+		}
+	}
+
+	if s := os.Getenv("MAGIC_LINK_TTL_MINUTES"); s != "" {
+		minutes, err := strconv.Atoi(s)
+		if err != nil || minutes < 1 {
+			log.Fatal("failed to parse MAGIC_LINK_TTL_MINUTES, is this int type?")
+		}
+		cfg.magicLink.ttl = time.Duration(minutes) * time.Minute
+	}
+
+	// who can register with POST /v1/users, "invite" needs an invitation from an
+	// admin and "domain" only allows emails on REGISTRATION_ALLOWED_DOMAINS that
+	// aren't from disposable email providers. Invitations work in every mode
+	// optional, defaults to open with invitations lasting 7 days
+	cfg.registration.invitationTTL = 7 * 24 * time.Hour
+
+	switch mode := os.Getenv("REGISTRATION_MODE"); mode {
+	case "", registrationOpen:
+		cfg.registration.mode = registrationOpen
+	case registrationInvite, registrationDomain:
+		cfg.registration.mode = mode
+	default:
+		log.Fatalf("failed to parse REGISTRATION_MODE, expected open, invite or domain but got %q", mode)
+	}
+
+	for _, domain := range strings.Fields(os.Getenv("REGISTRATION_ALLOWED_DOMAINS")) {
+		cfg.registration.allowedDomains = append(cfg.registration.allowedDomains, strings.ToLower(domain))
+	}
+
+	cfg.registration.disposableFile = os.Getenv("DISPOSABLE_DOMAINS_FILE")
+
+	if s := os.Getenv("INVITATION_TTL_DAYS"); s != "" {
+		days, err := strconv.Atoi(s)
+		if err != nil || days < 1 {
+			log.Fatal("failed to parse INVITATION_TTL_DAYS, is this int type?")
+		}
+		cfg.registration.invitationTTL = time.Duration(days) * 24 * time.Hour
+	}
+
+	// access tokens are stored in the db by default, "signed" issues stateless
+	// signed tokens instead, which authenticate can check without a db hit
+	// optional, defaults to database
+	switch mode := os.Getenv("AUTH_TOKEN_MODE"); mode {
+	case "", "database":
+	case "signed":
+		cfg.tokens.signed = true
+		cfg.tokens.keysetFile = os.Getenv("AUTH_KEYSET_FILE")
+		if cfg.tokens.keysetFile == "" {
+			log.Fatal("AUTH_KEYSET_FILE is required when AUTH_TOKEN_MODE is signed")
+		}
+	default:
+		log.Fatalf("failed to parse AUTH_TOKEN_MODE, expected database or signed but got %q", mode)
+	}
+
+	// keys stored tokens are hashed with, kept out of the db so a leaked copy of
+	// the tokens table can't be used to check guessed tokens
+	// optional, tokens are hashed with plain sha256 without it
+	cfg.tokens.pepperFile = os.Getenv("TOKEN_PEPPER_FILE")
+
 	return cfg
 }
@@ -5,12 +5,20 @@ import (
 	"net/http"
 
 	"github.com/V4N1LLA-1CE/movie-db-api/internal/data"
+	"github.com/V4N1LLA-1CE/movie-db-api/internal/jwt"
 )
 
 // custom contextKey type
 type contextKey string
 
-const userContextKey = contextKey("user")
+const (
+	userContextKey   = contextKey("user")
+	tokenContextKey  = contextKey("token")
+	claimsContextKey = contextKey("claims")
+	apiKeyContextKey = contextKey("apiKey")
+
+	permissionsContextKey = contextKey("permissions")
+)
 
 // takes a request and user, returns copy of request with the context embedded
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
@@ -31,3 +39,52 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 
 	return user
 }
+
+// takes a request and the authentication token it was made with
+func (app *application) contextSetToken(r *http.Request, token string) *http.Request {
+	ctx := context.WithValue(r.Context(), tokenContextKey, token)
+	return r.WithContext(ctx)
+}
+
+// returns the authentication token for the request, "" for anonymous users
+func (app *application) contextGetToken(r *http.Request) string {
+	token, _ := r.Context().Value(tokenContextKey).(string)
+	return token
+}
+
+// takes a request and the claims of the signed access token it was made with
+func (app *application) contextSetClaims(r *http.Request, claims *jwt.Claims) *http.Request {
+	ctx := context.WithValue(r.Context(), claimsContextKey, claims)
+	return r.WithContext(ctx)
+}
+
+// returns the signed access token claims for the request
+// nil if the request wasn't made with a signed token
+func (app *application) contextGetClaims(r *http.Request) *jwt.Claims {
+	claims, _ := r.Context().Value(claimsContextKey).(*jwt.Claims)
+	return claims
+}
+
+// takes a request and the api key it was made with
+func (app *application) contextSetAPIKey(r *http.Request, key *data.APIKey) *http.Request {
+	ctx := context.WithValue(r.Context(), apiKeyContextKey, key)
+	return r.WithContext(ctx)
+}
+
+// returns the api key for the request, nil if it wasn't made with one
+func (app *application) contextGetAPIKey(r *http.Request) *data.APIKey {
+	key, _ := r.Context().Value(apiKeyContextKey).(*data.APIKey)
+	return key
+}
+
+// takes a request and the permissions of the user behind it
+func (app *application) contextSetPermissions(r *http.Request, permissions data.Permissions) *http.Request {
+	ctx := context.WithValue(r.Context(), permissionsContextKey, permissions)
+	return r.WithContext(ctx)
+}
+
+// returns the permissions loaded for the request, nil if they haven't been yet
+func (app *application) contextGetPermissions(r *http.Request) data.Permissions {
+	permissions, _ := r.Context().Value(permissionsContextKey).(data.Permissions)
+	return permissions
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// sessionDenylist is the in-memory copy of the session_denylist table used to
+// reject signed access tokens for revoked sessions without a db lookup
+// NOTE: revocations made through another instance (or anything that isn't
+// logout on this one) take up to one sync interval to be picked up
+type sessionDenylist struct {
+	mu       sync.RWMutex
+	revoked  map[uuid.UUID]time.Time
+	lastSeen time.Time
+}
+
+// revoked_at is NOW() at the start of the revoking transaction, so a revocation
+// can commit with a time before the newest one already synced. Each sync reads
+// back this far before lastSeen to pick those up, the map drops duplicates
+const denylistSyncOverlap = time.Minute
+
+func newSessionDenylist() *sessionDenylist {
+	return &sessionDenylist{revoked: make(map[uuid.UUID]time.Time)}
+}
+
+func (d *sessionDenylist) isRevoked(familyID uuid.UUID) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	_, ok := d.revoked[familyID]
+	return ok
+}
+
+func (d *sessionDenylist) add(familyID uuid.UUID, revokedAt time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.revoked[familyID] = revokedAt
+}
+
+// pulls new revocations from the db and forgets entries older than maxAge,
+// by then any access token they could block has expired anyway
+func (app *application) syncSessionDenylist(maxAge time.Duration) error {
+	d := app.denylist
+
+	d.mu.RLock()
+	since := d.lastSeen
+	d.mu.RUnlock()
+
+	if since.IsZero() {
+		since = time.Now().Add(-maxAge)
+	} else {
+		since = since.Add(-denylistSyncOverlap)
+	}
+
+	revoked, err := app.models.Denylist.GetRevokedSince(since)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for familyID, revokedAt := range revoked {
+		d.revoked[familyID] = revokedAt
+
+		if revokedAt.After(d.lastSeen) {
+			d.lastSeen = revokedAt
+		}
+	}
+
+	if d.lastSeen.IsZero() {
+		d.lastSeen = since
+	}
+
+	cutoff := time.Now().Add(-maxAge)
+	for familyID, revokedAt := range d.revoked {
+		if revokedAt.Before(cutoff) {
+			delete(d.revoked, familyID)
+		}
+	}
+
+	return nil
+}
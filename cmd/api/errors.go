@@ -3,6 +3,8 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 // HTTP status codes
@@ -103,3 +105,33 @@ func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Requ
 	message := "your user account doesn't have the necessary permissions to access this resource"
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
+
+func (app *application) sessionRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this resource can't be accessed with an api key, log in to use it"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) deletionScheduledResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account is scheduled for deletion, use the link in the confirmation email to restore it"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) mfaRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account must have two-factor authentication enabled to access this resource"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) deactivatedAccountResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account has been deactivated by an administrator"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) tooManyLoginAttemptsResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	// round up so clients don't retry a moment too early
+	seconds := int((retryAfter + time.Second - 1) / time.Second)
+
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+
+	message := "too many failed login attempts, please try again later"
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
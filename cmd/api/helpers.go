@@ -5,10 +5,12 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/V4N1LLA-1CE/movie-db-api/internal/validator"
 	"github.com/julienschmidt/httprouter"
@@ -186,6 +188,48 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	return i
 }
 
+// returns nil when the param isn't set so callers can tell it apart from false
+func (app *application) readBool(qs url.Values, key string, v *validator.Validator) *bool {
+	s := qs.Get(key)
+	if s == "" {
+		return nil
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be true or false")
+		return nil
+	}
+
+	return &b
+}
+
+// reads an RFC 3339 timestamp i.e. 2024-06-01T00:00:00Z, nil when the param isn't set
+func (app *application) readTime(qs url.Values, key string, v *validator.Validator) *time.Time {
+	s := qs.Get(key)
+	if s == "" {
+		return nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		v.AddError(key, "must be an RFC 3339 timestamp i.e. 2024-06-01T00:00:00Z")
+		return nil
+	}
+
+	return &t
+}
+
+// returns the client's IP address without the port
+func (app *application) clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return ip
+}
+
 // helper to run background goroutines
 // this helper will manage app waitgroup
 func (app *application) background(fn func()) {
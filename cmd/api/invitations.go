@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/V4N1LLA-1CE/movie-db-api/internal/data"
+	"github.com/V4N1LLA-1CE/movie-db-api/internal/validator"
+)
+
+// registers an invited user, they get the invitation's permissions instead of
+// the default role and the invitation can't be used again
+func (app *application) registerInvitedUser(w http.ResponseWriter, r *http.Request, v *validator.Validator, user *data.User, invitationToken string) {
+	// the invitation was emailed to the user, which proves they own the address
+	// so there's nothing left for activation to check
+	user.Activated = true
+
+	invitation, err := app.models.Invitations.Accept(invitationToken, user)
+	if err != nil {
+		switch {
+		// used or revoked since checkRegistrationPolicy looked it up
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("invitation_token", "invalid or expired invitation")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.recordSecurityEvent(r, data.EventUserRegistered, data.OutcomeSuccess, user.ID, map[string]string{
+		"invitation":  strconv.FormatInt(invitation.ID, 10),
+		"permissions": strings.Join(invitation.Permissions, " "),
+	})
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// GET /v1/admin/invitations
+// lists invitations that haven't been used or expired
+func (app *application) listInvitationsHandler(w http.ResponseWriter, r *http.Request) {
+	invitations, err := app.models.Invitations.GetAllPending()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"invitations": invitations}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// POST /v1/admin/invitations
+// emails an invitation to register, inviting the same email again replaces the
+// earlier invitation
+func (app *application) createInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email       string   `json:"email"`
+		Permissions []string `json:"permissions"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	known, err := app.models.Permissions.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	invitation := &data.Invitation{
+		Email:       input.Email,
+		Permissions: input.Permissions,
+	}
+
+	v := validator.New()
+
+	if data.ValidateInvitation(v, invitation, known); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	_, err = app.models.Users.GetByEmail(invitation.Email)
+	switch {
+	case err == nil:
+		v.AddError("email", "a user with this email already exists")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	case !errors.Is(err, data.ErrRecordNotFound):
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	admin := app.contextGetUser(r)
+
+	invitation, err = app.models.Invitations.New(invitation.Email, invitation.Permissions, admin.ID, app.config.registration.invitationTTL)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.background(func() {
+		data := map[string]any{
+			"invitationToken": invitation.Plaintext,
+			"ttlDays":         int(app.config.registration.invitationTTL.Hours() / 24),
+		}
+
+		err := app.mailer.Send(invitation.Email, "user_invitation.tmpl", data)
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+	})
+
+	app.recordSecurityEvent(r, data.EventInvitationCreated, data.OutcomeSuccess, 0, map[string]string{
+		"email":       invitation.Email,
+		"permissions": strings.Join(invitation.Permissions, " "),
+	})
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"invitation": invitation}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// DELETE /v1/admin/invitations/:id
+func (app *application) deleteInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIdParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Invitations.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.recordSecurityEvent(r, data.EventInvitationRevoked, data.OutcomeSuccess, 0, map[string]string{"invitation": strconv.FormatInt(id, 10)})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "invitation successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
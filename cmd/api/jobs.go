@@ -0,0 +1,122 @@
+package main
+
+import (
+	"time"
+)
+
+// periodically hard deletes accounts whose deletion grace period has ended
+// runs for the lifetime of the process, a purge cut short by shutdown is
+// simply picked up again on the next start
+func (app *application) purgeDeletedUsers(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := app.models.Users.DeleteScheduled()
+		if err != nil {
+			app.logger.Error(err.Error())
+		} else if n > 0 {
+			app.logger.Info("purged deleted user accounts", "count", n)
+		}
+
+		<-ticker.C
+	}
+}
+
+// periodically removes expired tokens, rotated refresh tokens in particular
+// pile up quickly otherwise
+func (app *application) purgeExpiredTokens(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := app.models.Tokens.DeleteExpired()
+		if err != nil {
+			app.logger.Error(err.Error())
+		} else if n > 0 {
+			app.logger.Info("purged expired tokens", "count", n)
+		}
+
+		<-ticker.C
+	}
+}
+
+// writes session and api key last used times recorded by authenticate every
+// interval. anything recorded after the last flush is lost on shutdown, which
+// only makes last_used_at slightly stale
+func (app *application) flushLastUsed(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		err := app.models.Tokens.UpdateLastUsed(app.sessionActivity.drain())
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+
+		err = app.models.APIKeys.UpdateLastUsed(app.apiKeyActivity.drain())
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+	}
+}
+
+// keeps the in-memory session deny list in line with the db while signed
+// access tokens are enabled, old rows are cleared out every so often too
+func (app *application) refreshSessionDenylist(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	cleanup := time.NewTicker(time.Hour)
+	defer cleanup.Stop()
+
+	for {
+		err := app.syncSessionDenylist(app.config.tokens.accessTTL)
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+
+		select {
+		case <-ticker.C:
+		case <-cleanup.C:
+			_, err := app.models.Denylist.DeleteOlderThan(app.config.tokens.accessTTL)
+			if err != nil {
+				app.logger.Error(err.Error())
+			}
+		}
+	}
+}
+
+// periodically removes login failures that no longer count towards a lockout
+func (app *application) purgeLoginFailures(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := app.models.LoginFailures.DeleteStale()
+		if err != nil {
+			app.logger.Error(err.Error())
+		} else if n > 0 {
+			app.logger.Info("purged stale login failures", "count", n)
+		}
+
+		<-ticker.C
+	}
+}
+
+// periodically removes security events older than the retention period
+func (app *application) purgeSecurityEvents(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := app.models.SecurityEvents.DeleteOlderThan(app.config.securityEvents.retention)
+		if err != nil {
+			app.logger.Error(err.Error())
+		} else if n > 0 {
+			app.logger.Info("purged old security events", "count", n)
+		}
+
+		<-ticker.C
+	}
+}
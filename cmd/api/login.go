@@ -0,0 +1,241 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/V4N1LLA-1CE/movie-db-api/internal/data"
+	"github.com/V4N1LLA-1CE/movie-db-api/internal/validator"
+)
+
+// failed logins an email gets before each further attempt has to wait,
+// the wait doubles with every failure up to maxLoginDelay
+const (
+	freeLoginAttempts = 3
+	maxLoginDelay     = 30 * time.Second
+)
+
+// how long to wait after the last failure before trying again
+func loginDelay(failures int) time.Duration {
+	if failures < freeLoginAttempts {
+		return 0
+	}
+
+	delay := time.Second << min(failures-freeLoginAttempts, 5)
+
+	return min(delay, maxLoginDelay)
+}
+
+// ipLoginFailures counts failed logins per client IP in memory, an IP that
+// fails too often is locked out of logging in for a while
+type ipLoginFailures struct {
+	mu      sync.Mutex
+	clients map[string]*ipLoginFailure
+}
+
+type ipLoginFailure struct {
+	failures     int
+	lastFailedAt time.Time
+	lockedUntil  time.Time
+}
+
+func newIPLoginFailures(window time.Duration) *ipLoginFailures {
+	f := &ipLoginFailures{clients: make(map[string]*ipLoginFailure)}
+
+	// forget IPs that haven't failed or been locked for a window
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+
+			f.mu.Lock()
+
+			for ip, client := range f.clients {
+				if time.Since(client.lastFailedAt) > window && time.Now().After(client.lockedUntil) {
+					delete(f.clients, ip)
+				}
+			}
+
+			f.mu.Unlock()
+		}
+	}()
+
+	return f
+}
+
+// returns when the IP can try again, the zero time if it isn't locked
+func (f *ipLoginFailures) lockedUntil(ip string) time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if client, ok := f.clients[ip]; ok && time.Now().Before(client.lockedUntil) {
+		return client.lockedUntil
+	}
+
+	return time.Time{}
+}
+
+// counts a failure for the IP, reports true when it caused a lockout
+func (f *ipLoginFailures) record(ip string, maxFailures int, lockout time.Duration) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+
+	client, ok := f.clients[ip]
+	if !ok || now.Sub(client.lastFailedAt) > lockout {
+		client = &ipLoginFailure{}
+		f.clients[ip] = client
+	}
+
+	client.failures++
+	client.lastFailedAt = now
+
+	if client.failures < maxFailures {
+		return false
+	}
+
+	client.failures = 0
+	client.lockedUntil = now.Add(lockout)
+
+	return true
+}
+
+// checks the email and client IP haven't failed too many logins, writing a
+// 429 with Retry-After and reporting false if they have
+// this happens before the password is checked so a correct guess while
+// locked out doesn't get in either
+func (app *application) checkLoginThrottle(w http.ResponseWriter, r *http.Request, email string) bool {
+	now := time.Now()
+
+	if until := app.ipLoginFailures.lockedUntil(app.clientIP(r)); !until.IsZero() {
+		app.loginThrottledResponse(w, r, email, until.Sub(now))
+		return false
+	}
+
+	failure, err := app.models.LoginFailures.Get(email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	if failure == nil {
+		return true
+	}
+
+	if failure.Locked(now) {
+		app.loginThrottledResponse(w, r, email, failure.LockedUntil.Sub(now))
+		return false
+	}
+
+	if retryAt := failure.LastFailedAt.Add(loginDelay(failure.Failures)); now.Before(retryAt) {
+		app.loginThrottledResponse(w, r, email, retryAt.Sub(now))
+		return false
+	}
+
+	return true
+}
+
+// audits the refused login before sending the 429
+func (app *application) loginThrottledResponse(w http.ResponseWriter, r *http.Request, email string, retryAfter time.Duration) {
+	app.recordSecurityEvent(r, data.EventLogin, data.OutcomeFailure, 0, map[string]string{"email": email, "reason": "too many failed attempts"})
+	app.tooManyLoginAttemptsResponse(w, r, retryAfter)
+}
+
+// records a failed login against the email and client IP
+// user is nil when there's no account for the email, it's treated the same
+// except there's nobody to send an unlock email to
+func (app *application) recordLoginFailure(r *http.Request, email string, user *data.User) error {
+	ip := app.clientIP(r)
+
+	if app.ipLoginFailures.record(ip, app.config.login.maxFailuresPerIP, app.config.login.lockout) {
+		app.logger.Warn("ip locked out after failed logins", "ip", ip, "until", time.Now().Add(app.config.login.lockout))
+	}
+
+	failure, err := app.models.LoginFailures.Record(email, app.config.login.maxFailures, app.config.login.lockout)
+	if err != nil {
+		return err
+	}
+
+	if !failure.Locked(time.Now()) {
+		return nil
+	}
+
+	app.logger.Warn("account locked out after failed logins", "email", email, "ip", ip, "until", *failure.LockedUntil, "exists", user != nil)
+
+	if user == nil {
+		return nil
+	}
+
+	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeAccountUnlock)
+	if err != nil {
+		return err
+	}
+
+	app.background(func() {
+		data := map[string]any{
+			"unlockToken": token.Plaintext,
+			"ip":          ip,
+		}
+
+		err := app.mailer.Send(user.Email, "account_locked.tmpl", data)
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+	})
+
+	return nil
+}
+
+// PUT /v1/users/unlocked
+// lifts a login lockout early with the token from the lockout email
+func (app *application) unlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopeAccountUnlock, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired unlock token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.LoginFailures.Reset(user.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeAccountUnlock, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.logger.Info("account unlocked", "user_id", user.ID, "ip", app.clientIP(r))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "your account was unlocked, you can log in again"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
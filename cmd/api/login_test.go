@@ -0,0 +1,100 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginDelay(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{freeLoginAttempts - 1, 0},
+		{freeLoginAttempts, time.Second},
+		{freeLoginAttempts + 1, 2 * time.Second},
+		{freeLoginAttempts + 3, 8 * time.Second},
+		{freeLoginAttempts + 4, 16 * time.Second},
+		{freeLoginAttempts + 5, maxLoginDelay},
+		{freeLoginAttempts + 100, maxLoginDelay},
+	}
+
+	for _, tt := range tests {
+		if got := loginDelay(tt.failures); got != tt.want {
+			t.Errorf("loginDelay(%d) = %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestIPLoginFailuresRecord(t *testing.T) {
+	const (
+		maxFailures = 3
+		lockout     = time.Minute
+		ip          = "192.0.2.1"
+	)
+
+	t.Run("locks out at the threshold", func(t *testing.T) {
+		f := newIPLoginFailures(lockout)
+
+		for i := 1; i < maxFailures; i++ {
+			if f.record(ip, maxFailures, lockout) {
+				t.Fatalf("failure %d caused a lockout, want none before %d", i, maxFailures)
+			}
+
+			if !f.lockedUntil(ip).IsZero() {
+				t.Fatalf("locked after %d failures", i)
+			}
+		}
+
+		if !f.record(ip, maxFailures, lockout) {
+			t.Fatalf("failure %d didn't cause a lockout", maxFailures)
+		}
+
+		until := f.lockedUntil(ip)
+		if until.IsZero() {
+			t.Fatal("not locked after the lockout was reported")
+		}
+
+		if d := time.Until(until); d <= 0 || d > lockout {
+			t.Errorf("locked for %s, want up to %s", d, lockout)
+		}
+
+		if !f.lockedUntil("192.0.2.2").IsZero() {
+			t.Error("lockout applied to another IP")
+		}
+	})
+
+	t.Run("window resets the count", func(t *testing.T) {
+		f := newIPLoginFailures(lockout)
+
+		for i := 1; i < maxFailures; i++ {
+			f.record(ip, maxFailures, lockout)
+		}
+
+		// the last failure was longer ago than the window
+		f.clients[ip].lastFailedAt = time.Now().Add(-2 * lockout)
+
+		if f.record(ip, maxFailures, lockout) {
+			t.Fatal("failures from before the window counted towards the lockout")
+		}
+
+		if got := f.clients[ip].failures; got != 1 {
+			t.Errorf("failures = %d after the window reset, want 1", got)
+		}
+	})
+
+	t.Run("lockout expires", func(t *testing.T) {
+		f := newIPLoginFailures(lockout)
+
+		for i := 0; i < maxFailures; i++ {
+			f.record(ip, maxFailures, lockout)
+		}
+
+		f.clients[ip].lockedUntil = time.Now().Add(-time.Second)
+
+		if !f.lockedUntil(ip).IsZero() {
+			t.Error("still locked after the lockout ended")
+		}
+	})
+}
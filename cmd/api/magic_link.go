@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/V4N1LLA-1CE/movie-db-api/internal/data"
+	"github.com/V4N1LLA-1CE/movie-db-api/internal/validator"
+)
+
+// POST /v1/tokens/magic-link
+// emails a single-use login link, only routed when MAGIC_LINK_ENABLED is set
+func (app *application) createMagicLinkTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// limit per address so this can't be used to flood someone's inbox
+	// applied before the lookup so it behaves the same for unknown emails
+	if !app.emailLimiter.allow(strings.ToLower(input.Email)) {
+		app.rateLimitExceededResponse(w, r)
+		return
+	}
+
+	// respond the same way whether or not the email exists so this endpoint
+	// can't be used to find out which emails have accounts
+	env := envelope{"message": "if an account exists for this email, a login link will be sent to it"}
+
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			err = app.writeJSON(w, http.StatusAccepted, env, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// accounts that can't log in anyway don't get a link
+	if !user.IsDeactivated() && user.DeletionScheduledAt == nil {
+		token, err := app.models.Tokens.New(user.ID, app.config.magicLink.ttl, data.ScopeMagicLink)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.background(func() {
+			data := map[string]any{
+				"magicLinkToken": token.Plaintext,
+				"ttlMinutes":     int(app.config.magicLink.ttl.Minutes()),
+			}
+
+			err := app.mailer.Send(user.Email, "token_magic_link.tmpl", data)
+			if err != nil {
+				app.logger.Error(err.Error())
+			}
+		})
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// POST /v1/tokens/magic-link/authentication
+// swaps the token from the email for a session, the same as logging in with a
+// password. Two-factor authentication still applies
+func (app *application) createMagicLinkAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// the token is deleted as it's checked so it can't be used twice
+	userID, err := app.models.Tokens.Consume(data.ScopeMagicLink, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.recordSecurityEvent(r, data.EventLoginMagicLink, data.OutcomeFailure, 0, map[string]string{"reason": "invalid or expired token"})
+			v.AddError("token", "invalid or expired login token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.models.Users.Get(userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// any other links that were sent stop working too
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeMagicLink, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if user.IsDeactivated() {
+		app.recordSecurityEvent(r, data.EventLoginMagicLink, data.OutcomeFailure, user.ID, map[string]string{"reason": "account deactivated"})
+		app.deactivatedAccountResponse(w, r)
+		return
+	}
+
+	if user.DeletionScheduledAt != nil {
+		app.recordSecurityEvent(r, data.EventLoginMagicLink, data.OutcomeFailure, user.ID, map[string]string{"reason": "account scheduled for deletion"})
+		app.deletionScheduledResponse(w, r)
+		return
+	}
+
+	// following the link proves the user owns the email, which is all
+	// activation checks for
+	if !user.Activated {
+		user.Activated = true
+
+		err = app.models.Users.Update(user)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrUpdateConflict):
+				app.updateConflictResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		err = app.models.Tokens.DeleteAllForUser(data.ScopeActivation, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.recordSecurityEvent(r, data.EventUserActivated, data.OutcomeSuccess, user.ID, map[string]string{"via": "magic link"})
+	}
+
+	app.completeLogin(w, r, user, data.EventLoginMagicLink)
+}
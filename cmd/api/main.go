@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"database/sql"
+	"expvar"
 	"flag"
 	"fmt"
 	"log"
@@ -12,7 +13,10 @@ import (
 	"sync"
 	"time"
 
+	"github.com/V4N1LLA-1CE/movie-db-api/internal/breached"
 	"github.com/V4N1LLA-1CE/movie-db-api/internal/data"
+	"github.com/V4N1LLA-1CE/movie-db-api/internal/disposable"
+	"github.com/V4N1LLA-1CE/movie-db-api/internal/jwt"
 	"github.com/V4N1LLA-1CE/movie-db-api/internal/mailer"
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/joho/godotenv"
@@ -21,14 +25,25 @@ import (
 const version = "1.0.0"
 
 type application struct {
-	config config
-	logger *slog.Logger
-	models data.Models
-	mailer mailer.Mailer
-	wg     sync.WaitGroup
+	config             config
+	logger             *slog.Logger
+	models             data.Models
+	mailer             mailer.Mailer
+	wg                 sync.WaitGroup
+	emailLimiter       *keyedLimiter
+	mfaLimiter         *keyedLimiter
+	authFailureLimiter *keyedLimiter // caps audited authentication failures per IP
+	ipLoginFailures    *ipLoginFailures
+	sessionActivity    *lastUsedTracker[string] // keyed by token plaintext
+	apiKeyActivity     *lastUsedTracker[int64]  // keyed by api key id
+	keyset             *jwt.Keyset              // nil unless signed access tokens are enabled
+	denylist           *sessionDenylist
+	permissionCache    *permissionCache
+	disposable         *disposable.List // email domains turned away in domain registration mode
 }
 
-func init() {
+// called from main rather than init so tests in this package don't need a .env
+func loadEnv() {
 	// load .env file, otherwise exit
 	if err := godotenv.Load(); err != nil {
 		log.Fatalf("error loading .env filel: %v\n", err)
@@ -61,6 +76,8 @@ func init() {
 }
 
 func main() {
+	loadEnv()
+
 	// get app configuration
 	cfg := newConfig()
 
@@ -77,6 +94,52 @@ func main() {
 	// initialise structured logger
 	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
 
+	// passwords that have shown up in breaches can't be used for new passwords
+	breachedPasswords := breached.Common()
+
+	if cfg.passwords.breachedFile != "" {
+		list, err := breached.Load(cfg.passwords.breachedFile)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+
+		breachedPasswords = breachedPasswords.Merge(list)
+	}
+
+	data.SetBreachedPasswords(breachedPasswords)
+	logger.Info("breached password list loaded", "count", breachedPasswords.Len())
+
+	// only checked in domain registration mode but cheap enough to always load
+	disposableDomains := disposable.Common()
+
+	if cfg.registration.disposableFile != "" {
+		list, err := disposable.Load(cfg.registration.disposableFile)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+
+		disposableDomains = disposableDomains.Merge(list)
+	}
+
+	logger.Info("registration policy loaded", "mode", cfg.registration.mode, "disposable_domains", disposableDomains.Len())
+
+	// tokens issued before a pepper was configured keep working until they
+	// expire or are retired with cmd/tokenkeys
+	if cfg.tokens.pepperFile != "" {
+		tokenKeys, err := data.LoadTokenKeys(cfg.tokens.pepperFile)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+
+		data.SetTokenKeys(tokenKeys)
+		logger.Info("token pepper loaded", "active_key", tokenKeys.Active())
+	} else {
+		logger.Warn("TOKEN_PEPPER_FILE is not set, tokens are hashed without a pepper")
+	}
+
 	// connection pool for db
 	conn, err := openDB(cfg)
 	if err != nil {
@@ -92,6 +155,37 @@ func main() {
 		logger: logger,
 		models: data.NewModels(conn),
 		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		// limits emails sent to a single address to 3 at once, then 1 every 5 minutes
+		emailLimiter: newKeyedLimiter(1.0/300, 3),
+		// limits two-factor code attempts per user to 5 at once, then 1 every 30 seconds
+		mfaLimiter: newKeyedLimiter(1.0/30, 5),
+		// audits 10 authentication failures per IP at once, then 1 a minute
+		authFailureLimiter: newKeyedLimiter(1.0/60, 10),
+		ipLoginFailures:    newIPLoginFailures(cfg.login.lockout),
+		sessionActivity:    newLastUsedTracker[string](),
+		apiKeyActivity:     newLastUsedTracker[int64](),
+		denylist:           newSessionDenylist(),
+		permissionCache:    newPermissionCache(cfg.permissions.cacheTTL),
+		disposable:         disposableDomains,
+	}
+
+	// cache hits and misses are shown at /debug/vars
+	expvar.Publish("permission_cache", expvar.Func(app.permissionCache.metrics))
+
+	// load signing keys and revoked sessions before accepting signed tokens
+	if cfg.tokens.signed {
+		app.keyset, err = jwt.LoadKeyset(cfg.tokens.keysetFile)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+
+		err = app.syncSessionDenylist(cfg.tokens.accessTTL)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		logger.Info("signed access tokens enabled")
 	}
 
 	// start server
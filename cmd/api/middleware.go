@@ -5,12 +5,14 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/V4N1LLA-1CE/movie-db-api/internal/data"
 	"github.com/V4N1LLA-1CE/movie-db-api/internal/validator"
+	"github.com/google/uuid"
 	"golang.org/x/time/rate"
 )
 
@@ -32,20 +34,28 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 	})
 }
 
+// client struct for holding rate limiter and last seen time
+type rateLimitClient struct {
+	limiter           *rate.Limiter
+	mostRecentRequest time.Time
+}
+
+// keyedLimiter holds a token bucket per key (i.e. client IP)
 // NOTE: This method of rate limiting will only work if API is hosted on a single machine
 // If infra is distributed with multiple servers and load balancer, it won't work
-func (app *application) rateLimit(next http.Handler) http.Handler {
-	// client struct for holding rate limiter and last seen time
-	type client struct {
-		limiter           *rate.Limiter
-		mostRecentRequest time.Time
-	}
+type keyedLimiter struct {
+	mu      sync.Mutex
+	clients map[string]*rateLimitClient
+	rps     float64
+	burst   int
+}
 
-	// map of clients that get mapped by client IP addresses
-	var (
-		mu      sync.Mutex
-		clients = make(map[string]*client)
-	)
+func newKeyedLimiter(rps float64, burst int) *keyedLimiter {
+	l := &keyedLimiter{
+		clients: make(map[string]*rateLimitClient),
+		rps:     rps,
+		burst:   burst,
+	}
 
 	// background goroutine to cleanup clients every minute
 	go func() {
@@ -53,48 +63,85 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 			time.Sleep(time.Minute)
 
 			// lock for cleanup
-			mu.Lock()
+			l.mu.Lock()
 
-			// if client hasn't requested for 3 minutes, delete from map
-			for ip, client := range clients {
-				if time.Since(client.mostRecentRequest) > 3*time.Minute {
-					delete(clients, ip)
+			// if client hasn't requested for 3 minutes and their bucket has refilled, delete from map
+			// slow limiters (i.e. per-email) keep their client until it would be fresh anyway
+			for key, client := range l.clients {
+				if time.Since(client.mostRecentRequest) > 3*time.Minute && client.limiter.Tokens() >= float64(l.burst) {
+					delete(l.clients, key)
 				}
 			}
 
 			// unlock mutex
-			mu.Unlock()
+			l.mu.Unlock()
 		}
 	}()
 
+	return l
+}
+
+// reports whether a request for key is allowed right now
+func (l *keyedLimiter) allow(key string) bool {
+	// lock mutex to allow only 1 goroutine to read/write to clients map at a time
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// check if key exists in map, if not, add a new client and add to map with key
+	if _, exists := l.clients[key]; !exists {
+		l.clients[key] = &rateLimitClient{limiter: rate.NewLimiter(rate.Limit(l.rps), l.burst)}
+	}
+
+	// update client's most recent request
+	l.clients[key].mostRecentRequest = time.Now()
+
+	return l.clients[key].limiter.Allow()
+}
+
+// paths that skip the global rate limiter because they apply their own
+var rateLimitExemptPaths = []string{
+	"/v1/movies/suggest",
+}
+
+func (app *application) rateLimit(next http.Handler) http.Handler {
+	limiter := newKeyedLimiter(app.config.limiter.rps, app.config.limiter.burst)
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// only carry out check if rate limiting is enabled
-		if app.config.limiter.enabled {
+		if app.config.limiter.enabled && !slices.Contains(rateLimitExemptPaths, r.URL.Path) {
 			ip, _, err := net.SplitHostPort(r.RemoteAddr)
 			if err != nil {
 				app.serverErrorResponse(w, r, err)
 				return
 			}
 
-			// lock mutex to allow only 1 goroutine to read/write to clients map at a time
-			mu.Lock()
-
-			// check if ip exists in map, if not, add a new client and add to map with ip
-			if _, exists := clients[ip]; !exists {
-				clients[ip] = &client{limiter: rate.NewLimiter(rate.Limit(app.config.limiter.rps), app.config.limiter.burst)}
+			if !limiter.allow(ip) {
+				app.rateLimitExceededResponse(w, r)
+				return
 			}
+		}
 
-			// update client's most recent request
-			clients[ip].mostRecentRequest = time.Now()
+		next.ServeHTTP(w, r)
+	})
+}
 
-			if !clients[ip].limiter.Allow() {
-				mu.Unlock()
-				app.rateLimitExceededResponse(w, r)
+// per-route rate limiter with its own rps and burst, keyed by client IP
+// use on handlerfunc, not router
+func (app *application) rateLimitRoute(rps float64, burst int, next http.HandlerFunc) http.HandlerFunc {
+	limiter := newKeyedLimiter(rps, burst)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.config.limiter.enabled {
+			ip, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
 				return
 			}
 
-			// unlock mutex
-			mu.Unlock()
+			if !limiter.allow(ip) {
+				app.rateLimitExceededResponse(w, r)
+				return
+			}
 		}
 
 		next.ServeHTTP(w, r)
@@ -105,11 +152,59 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// add "Vary: Authorization" header to response
 		w.Header().Add("Vary", "Authorization")
+		w.Header().Add("Vary", "X-API-Key")
 
 		// retrieve value of authorization header from request
 		// return "" if no header found
 		authorizationHeader := r.Header.Get("Authorization")
 
+		// api keys come in either "X-API-Key: <key>" or "Authorization: ApiKey <key>"
+		apiKey := r.Header.Get("X-API-Key")
+		if scheme, key, ok := strings.Cut(authorizationHeader, " "); ok && scheme == "ApiKey" {
+			apiKey = key
+		}
+
+		if apiKey != "" {
+			if !data.IsAPIKeyPlaintext(apiKey) {
+				app.recordAuthenticationFailure(r, 0, "invalid api key")
+				app.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+
+			key, user, err := app.models.APIKeys.GetForKey(apiKey)
+			if err != nil {
+				switch {
+				case errors.Is(err, data.ErrRecordNotFound):
+					app.recordAuthenticationFailure(r, 0, "invalid api key")
+					app.invalidAuthenticationTokenResponse(w, r)
+				default:
+					app.serverErrorResponse(w, r, err)
+				}
+				return
+			}
+
+			if user.IsDeactivated() {
+				app.recordAuthenticationFailure(r, user.ID, "account deactivated")
+				app.deactivatedAccountResponse(w, r)
+				return
+			}
+
+			// keys are deleted when deletion is scheduled, this covers anything that slips through
+			if user.DeletionScheduledAt != nil {
+				app.recordAuthenticationFailure(r, user.ID, "account scheduled for deletion")
+				app.deletionScheduledResponse(w, r)
+				return
+			}
+
+			app.apiKeyActivity.touch(key.ID)
+
+			r = app.contextSetUser(r, user)
+			r = app.contextSetAPIKey(r, key)
+
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		// if no token found in header, set the request context to anonymous user
 		// and serve next request
 		if authorizationHeader == "" {
@@ -123,17 +218,47 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		// if wrong format, send 401 unauthorized
 		headerParts := strings.Split(authorizationHeader, " ")
 		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+			app.recordAuthenticationFailure(r, 0, "malformed authorization header")
 			app.invalidAuthenticationTokenResponse(w, r)
 			return
 		}
 
 		token := headerParts[1]
 
+		// signed tokens are checked without going to the db, database tokens
+		// issued before they were enabled keep working until they expire
+		if app.keyset != nil && strings.Contains(token, ".") {
+			claims, err := app.keyset.Verify(token, time.Now())
+			if err != nil {
+				app.recordAuthenticationFailure(r, 0, "invalid token")
+				app.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+
+			// deactivating a user revokes their sessions, which puts them on the deny-list
+			familyID, err := uuid.Parse(claims.SessionID)
+			if err != nil || app.denylist.isRevoked(familyID) {
+				app.recordAuthenticationFailure(r, claims.Subject, "revoked session")
+				app.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+
+			// only what the auth checks need, see requireUserRecord
+			user := &data.User{ID: claims.Subject, Activated: claims.Activated}
+
+			r = app.contextSetUser(r, user)
+			r = app.contextSetClaims(r, claims)
+
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		// validate token
 		v := validator.New()
 
 		data.ValidateTokenPlaintext(v, token)
 		if !v.Valid() {
+			app.recordAuthenticationFailure(r, 0, "invalid token")
 			app.invalidAuthenticationTokenResponse(w, r)
 			return
 		}
@@ -143,6 +268,7 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		if err != nil {
 			switch {
 			case errors.Is(err, data.ErrRecordNotFound):
+				app.recordAuthenticationFailure(r, 0, "invalid token")
 				app.invalidCredentialsResponse(w, r)
 			default:
 				app.serverErrorResponse(w, r, err)
@@ -150,8 +276,19 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
-		// set user for request context
+		// tokens are revoked on deactivation, this covers anything that slips through
+		if user.IsDeactivated() {
+			app.recordAuthenticationFailure(r, user.ID, "account deactivated")
+			app.deactivatedAccountResponse(w, r)
+			return
+		}
+
+		// remember the token was used, written to the db in batches
+		app.sessionActivity.touch(token)
+
+		// set user and token for request context
 		r = app.contextSetUser(r, user)
+		r = app.contextSetToken(r, token)
 
 		// go to next request
 		next.ServeHTTP(w, r)
@@ -191,21 +328,88 @@ func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.Han
 	})
 }
 
+// api keys are for machine clients and only carry the permissions they were
+// given, so they can't be used on the account itself, its sessions or its keys
+func (app *application) requireSessionAuth(next http.HandlerFunc) http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if app.contextGetAPIKey(r) != nil {
+			app.sessionRequiredResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+
+	return app.requireAuthenticatedUser(fn)
+}
+
+// signed access tokens only carry what's needed for auth checks, handlers
+// that work with the user's full record use this to load the rest of it
+func (app *application) requireUserRecord(next http.HandlerFunc) http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if app.contextGetClaims(r) != nil {
+			user, err := app.models.Users.Get(app.contextGetUser(r).ID)
+			if err != nil {
+				switch {
+				case errors.Is(err, data.ErrRecordNotFound):
+					app.invalidAuthenticationTokenResponse(w, r)
+				default:
+					app.serverErrorResponse(w, r, err)
+				}
+				return
+			}
+
+			r = app.contextSetUser(r, user)
+		}
+
+		next.ServeHTTP(w, r)
+	}
+
+	return app.requireAuthenticatedUser(fn)
+}
+
 func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
+	return app.requireAnyPermission([]string{code}, next)
+}
+
+// lets the request through if the user has at least one of codes, handlers
+// can use hasPermission to find out which
+func (app *application) requireAnyPermission(codes []string, next http.HandlerFunc) http.HandlerFunc {
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		user := app.contextGetUser(r)
 
-		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+		// kept in the context so handlers can check other codes for free
+		permissions, err := app.requestPermissions(r)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
 		}
 
-		if !permissions.Include(code) {
+		r = app.contextSetPermissions(r, permissions)
+
+		allowed := slices.ContainsFunc(codes, func(code string) bool {
+			return app.allows(r, permissions, code)
+		})
+
+		if !allowed {
 			app.notPermittedResponse(w, r)
 			return
 		}
 
+		// writers can be required to have two-factor authentication on
+		if app.config.accounts.mfaRequiredForWrite && slices.ContainsFunc(codes, isMoviesWritePermission) {
+			ok, err := app.mfaSatisfied(r, user)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+
+			if !ok {
+				app.mfaRequiredResponse(w, r)
+				return
+			}
+		}
+
 		next.ServeHTTP(w, r)
 	}
 
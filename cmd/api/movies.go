@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/V4N1LLA-1CE/movie-db-api/internal/data"
 	"github.com/V4N1LLA-1CE/movie-db-api/internal/validator"
@@ -14,10 +15,15 @@ import (
 func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Request) {
 	// create struct to hold data from post
 	var input struct {
-		Title   string   `json:"title"`
-		Year    int32    `json:"year"`
-		Runtime int32    `json:"runtime"`
-		Genres  []string `json:"genres"`
+		Title     string   `json:"title"`
+		Year      int32    `json:"year"`
+		Runtime   int32    `json:"runtime"`
+		Genres    []string `json:"genres"`
+		Plot      string   `json:"plot"`
+		Tagline   string   `json:"tagline"`
+		Language  string   `json:"original_language"`
+		Countries []string `json:"countries"`
+		Homepage  string   `json:"homepage"`
 	}
 
 	// decode request body as json and into input struct
@@ -28,12 +34,27 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 	}
 
 	movie := &data.Movie{
-		Title:   input.Title,
-		Year:    input.Year,
-		Runtime: input.Runtime,
-		Genres:  input.Genres,
+		Title:     input.Title,
+		Year:      input.Year,
+		Runtime:   input.Runtime,
+		Genres:    input.Genres,
+		Plot:      input.Plot,
+		Tagline:   input.Tagline,
+		Language:  input.Language,
+		Countries: input.Countries,
+		Homepage:  input.Homepage,
 	}
 
+	// countries is optional and the column is NOT NULL, a nil slice would be
+	// stored as NULL
+	if movie.Countries == nil {
+		movie.Countries = []string{}
+	}
+
+	// the creator can keep editing it with just movies:write:own
+	createdBy := app.contextGetUser(r).ID
+	movie.CreatedBy = &createdBy
+
 	// initialise validator
 	v := validator.New()
 
@@ -89,22 +110,9 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 }
 
 func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Request) {
-	// read :id from url param
-	id, err := app.readIdParam(r)
-	if err != nil {
-		app.notFoundResponse(w, r)
-		return
-	}
-
-	// get original movie into a struct
-	movie, err := app.models.Movies.Get(id)
-	if err != nil {
-		switch {
-		case errors.Is(err, data.ErrRecordNotFound):
-			app.notFoundResponse(w, r)
-		default:
-			app.serverErrorResponse(w, r, err)
-		}
+	// get original movie into a struct, collaborators can edit it too
+	movie, ok := app.readMovieForWrite(w, r, movieAccessCollaborator)
+	if !ok {
 		return
 	}
 
@@ -128,14 +136,19 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 	// if theres no corresponding key in JSON, it will be nil
 	// slice already has non zero so no need to use ptrs
 	var input struct {
-		Title   *string  `json:"title"`
-		Year    *int32   `json:"year"`
-		Runtime *int32   `json:"runtime"`
-		Genres  []string `json:"genres"`
+		Title     *string  `json:"title"`
+		Year      *int32   `json:"year"`
+		Runtime   *int32   `json:"runtime"`
+		Genres    []string `json:"genres"`
+		Plot      *string  `json:"plot"`
+		Tagline   *string  `json:"tagline"`
+		Language  *string  `json:"original_language"`
+		Countries []string `json:"countries"`
+		Homepage  *string  `json:"homepage"`
 	}
 
 	// read req body and put data into input struct
-	err = app.readJSON(w, r, &input)
+	err := app.readJSON(w, r, &input)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
 		return
@@ -161,6 +174,27 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		movie.Genres = input.Genres
 	}
 
+	// descriptive fields can be cleared by sending an empty value
+	if input.Plot != nil {
+		movie.Plot = *input.Plot
+	}
+
+	if input.Tagline != nil {
+		movie.Tagline = *input.Tagline
+	}
+
+	if input.Language != nil {
+		movie.Language = *input.Language
+	}
+
+	if input.Countries != nil {
+		movie.Countries = input.Countries
+	}
+
+	if input.Homepage != nil {
+		movie.Homepage = *input.Homepage
+	}
+
 	// validate updated movie record
 	// send 422 Unprocessable Entity response
 	// if checks fail
@@ -190,15 +224,14 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 }
 
 func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Request) {
-	// extract the movie id from the url
-	id, err := app.readIdParam(r)
-	if err != nil {
-		app.notFoundResponse(w, r)
+	// only the creator can delete a movie out of the ones they can edit
+	movie, ok := app.readMovieForWrite(w, r, movieAccessOwner)
+	if !ok {
 		return
 	}
 
 	// delete movie from db, send 404 to client if there's no matching record
-	err = app.models.Movies.Delete(id)
+	err := app.models.Movies.Delete(movie.ID)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -221,6 +254,7 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 	var input struct {
 		Title   string
 		Genres  []string
+		Filter  *data.FilterExpr
 		Filters data.Filters
 	}
 
@@ -234,6 +268,14 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 	input.Title = app.readString(qs, "title", "")
 	input.Genres = app.readCSV(qs, "genres", []string{})
 
+	// parse filter expression i.e. ?filter=year>=1990 and genres has "comedy"
+	// errors include the position in the expression where parsing failed
+	filter, err := data.ParseFilterExpr(app.readString(qs, "filter", ""), data.MovieFilterFields)
+	if err != nil {
+		v.AddError("filter", err.Error())
+	}
+	input.Filter = filter
+
 	// default is 1 page with 20 size
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
@@ -260,14 +302,95 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 	}
 
 	// call GetAll() to retreive movies and metadata of query
-	movies, metadata, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Filters)
+	movies, metadata, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Filter, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
+	env := envelope{"movies": movies, "metadata": metadata}
+
+	// if a search matches nothing, suggest similar titles/genres so the client
+	// has somewhere to go from a "no results" page. An empty page past the end
+	// of a search that did match doesn't count
+	if metadata.TotalRecords == 0 && (input.Title != "" || len(input.Genres) > 0) {
+		corrections, err := app.models.Movies.Corrections(input.Title, input.Genres)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !corrections.Empty() {
+			env["did_you_mean"] = corrections
+		}
+	}
+
 	// send json response
-	err = app.writeJSON(w, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// GET /v1/movies/suggest?prefix=
+func (app *application) suggestMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	prefix := strings.TrimSpace(app.readString(qs, "prefix", ""))
+	limit := app.readInt(qs, "limit", 10, v)
+
+	v.Check(prefix != "", "prefix", "must be provided")
+	v.Check(len(prefix) <= 100, "prefix", "must not be more than 100 bytes long")
+	v.Check(limit > 0, "limit", "must be greater than 0")
+	v.Check(limit <= 20, "limit", "must be a maximum of 20")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	suggestions, err := app.models.Movies.Suggest(prefix, limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// let the browser reuse results when the user backspaces
+	headers := make(http.Header)
+	headers.Set("Cache-Control", "private, max-age=30")
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"suggestions": suggestions}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// GET /v1/movies/changes?since=<token>
+func (app *application) listMovieChangesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	since := data.ParseChangeToken(v, app.readString(qs, "since", ""))
+	limit := app.readInt(qs, "limit", 100, v)
+
+	v.Check(limit > 0, "limit", "must be greater than 0")
+	v.Check(limit <= 1000, "limit", "must be a maximum of 1000")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	changes, err := app.models.Movies.GetChanges(since, limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"changes": changes}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
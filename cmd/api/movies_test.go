@@ -0,0 +1,49 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/V4N1LLA-1CE/movie-db-api/internal/data"
+)
+
+func TestCreateMovieHandlerWithoutCountries(t *testing.T) {
+	db, rec := newRecordingDB(
+		[]string{"id", "created_at", "updated_at", "version"},
+		int64(1), time.Now(), time.Now(), "0b6f8d2e-6a43-4c55-8a3e-2f1f3c1b7d9a",
+	)
+	defer db.Close()
+
+	app := &application{
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		models: data.NewModels(db),
+	}
+
+	// a body from a client that doesn't know about countries
+	body := `{"title": "Moana", "year": 2016, "runtime": 107, "genres": ["animation", "adventure"]}`
+
+	rr := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/v1/movies", strings.NewReader(body))
+	r = app.contextSetUser(r, &data.User{ID: 1})
+
+	app.createMovieHandler(rr, r)
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want %d: %s", rr.Code, http.StatusCreated, rr.Body)
+	}
+
+	q := rec.lastQuery()
+	if !strings.Contains(q.query, "INSERT INTO movies") {
+		t.Fatalf("last query = %q, want the movie insert", q.query)
+	}
+
+	// countries is the 8th placeholder and the column is NOT NULL
+	if countries := q.args[7]; countries != "{}" {
+		t.Errorf("countries = %#v, want an empty array", countries)
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/V4N1LLA-1CE/movie-db-api/internal/data"
+	"github.com/jackc/pgx/v5"
+)
+
+// channel the db notifies on when grants change, see migration 000021
+const permissionsChangedChannel = "permissions_changed"
+
+// permissionCache keeps each user's effective permissions in memory so
+// requirePermission doesn't have to join three tables on every request
+// entries expire after ttl, and are dropped straight away when grants are
+// changed through this instance or, with LISTEN enabled, any other
+type permissionCache struct {
+	mu         sync.RWMutex
+	entries    map[int64]permissionCacheEntry
+	ttl        time.Duration // 0 disables the cache
+	generation uint64        // bumped on every invalidation, see set
+
+	hits          atomic.Int64
+	misses        atomic.Int64
+	invalidations atomic.Int64
+}
+
+type permissionCacheEntry struct {
+	permissions data.Permissions
+	expiry      time.Time
+}
+
+func newPermissionCache(ttl time.Duration) *permissionCache {
+	c := &permissionCache{
+		entries: make(map[int64]permissionCacheEntry),
+		ttl:     ttl,
+	}
+
+	// background goroutine to remove expired entries every minute
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+
+			c.mu.Lock()
+
+			for userID, entry := range c.entries {
+				if time.Now().After(entry.expiry) {
+					delete(c.entries, userID)
+				}
+			}
+
+			c.mu.Unlock()
+		}
+	}()
+
+	return c
+}
+
+// returns the cached permissions and the current generation, which has to be
+// passed to set when the permissions weren't cached
+func (c *permissionCache) get(userID int64) (data.Permissions, uint64, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	entry, ok := c.entries[userID]
+	if !ok || time.Now().After(entry.expiry) {
+		c.misses.Add(1)
+		return nil, c.generation, false
+	}
+
+	c.hits.Add(1)
+	return entry.permissions, c.generation, true
+}
+
+// caches permissions loaded while the cache was at generation, unless
+// something was invalidated since then and the permissions could be stale
+func (c *permissionCache) set(userID int64, permissions data.Permissions, generation uint64) {
+	if c.ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+
+	c.entries[userID] = permissionCacheEntry{permissions: permissions, expiry: time.Now().Add(c.ttl)}
+}
+
+func (c *permissionCache) invalidate(userID int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, userID)
+	c.generation++
+	c.invalidations.Add(1)
+}
+
+func (c *permissionCache) invalidateAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	clear(c.entries)
+	c.generation++
+	c.invalidations.Add(1)
+}
+
+// counters shown at /debug/vars
+func (c *permissionCache) metrics() any {
+	c.mu.RLock()
+	size := len(c.entries)
+	c.mu.RUnlock()
+
+	return map[string]any{
+		"hits":          c.hits.Load(),
+		"misses":        c.misses.Load(),
+		"invalidations": c.invalidations.Load(),
+		"size":          size,
+	}
+}
+
+// returns the user's effective permissions through the cache
+func (app *application) cachedPermissions(userID int64) (data.Permissions, error) {
+	permissions, generation, ok := app.permissionCache.get(userID)
+	if ok {
+		return permissions, nil
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	app.permissionCache.set(userID, permissions, generation)
+
+	return permissions, nil
+}
+
+// returns the permissions of the user behind the request, loaded at most once
+// per request. Signed tokens carry the permissions they were issued with
+func (app *application) requestPermissions(r *http.Request) (data.Permissions, error) {
+	if permissions := app.contextGetPermissions(r); permissions != nil {
+		return permissions, nil
+	}
+
+	if claims := app.contextGetClaims(r); claims != nil {
+		return claims.Permissions, nil
+	}
+
+	return app.cachedPermissions(app.contextGetUser(r).ID)
+}
+
+// whether the request can use code given the user's permissions
+// api keys are limited to the permissions they were created with
+func (app *application) allows(r *http.Request, permissions data.Permissions, code string) bool {
+	if key := app.contextGetAPIKey(r); key != nil {
+		return key.Allows(code, permissions)
+	}
+
+	return permissions.Include(code)
+}
+
+// same as allows, loading the user's permissions if they haven't been yet
+func (app *application) hasPermission(r *http.Request, code string) (bool, error) {
+	permissions, err := app.requestPermissions(r)
+	if err != nil {
+		return false, err
+	}
+
+	return app.allows(r, permissions, code), nil
+}
+
+// permissions that let a user change movies, MFA_REQUIRED_FOR_WRITE applies to all of them
+var moviesWritePermissions = []string{"movies:write", "movies:write:own"}
+
+func isMoviesWritePermission(code string) bool {
+	return slices.Contains(moviesWritePermissions, code)
+}
+
+// listens for grant changes made through any instance and drops the affected
+// cache entries. Runs for the lifetime of the process, reconnecting if the
+// connection drops, and clears the whole cache when it does since
+// notifications sent while disconnected are lost
+func (app *application) listenPermissionChanges(retry time.Duration) {
+	for {
+		err := app.waitForPermissionChanges()
+		app.logger.Error("permission change listener stopped", "error", err.Error())
+
+		app.permissionCache.invalidateAll()
+
+		time.Sleep(retry)
+	}
+}
+
+func (app *application) waitForPermissionChanges() error {
+	ctx := context.Background()
+
+	conn, err := pgx.Connect(ctx, app.config.db.dsn)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(ctx)
+
+	_, err = conn.Exec(ctx, "LISTEN "+permissionsChangedChannel)
+	if err != nil {
+		return err
+	}
+
+	app.logger.Info("listening for permission changes")
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+
+		if notification.Payload == "*" {
+			app.permissionCache.invalidateAll()
+			continue
+		}
+
+		userID, err := strconv.ParseInt(notification.Payload, 10, 64)
+		if err != nil {
+			app.permissionCache.invalidateAll()
+			continue
+		}
+
+		app.permissionCache.invalidate(userID)
+	}
+}
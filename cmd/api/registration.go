@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/V4N1LLA-1CE/movie-db-api/internal/data"
+	"github.com/V4N1LLA-1CE/movie-db-api/internal/validator"
+)
+
+// registration modes, see REGISTRATION_MODE
+const (
+	registrationOpen   = "open"
+	registrationInvite = "invite"
+	registrationDomain = "domain"
+)
+
+// checks whether email may register under the configured mode, problems are
+// added to v. An invitation token is checked in every mode so admins can bring
+// in people the domain rules would turn away, the invitation is returned so
+// its permissions can be granted
+func (app *application) checkRegistrationPolicy(v *validator.Validator, email, invitationToken string) (*data.Invitation, error) {
+	if invitationToken != "" {
+		if !data.IsTokenPlaintext(invitationToken) {
+			v.AddError("invitation_token", "is not a valid token")
+			return nil, nil
+		}
+
+		invitation, err := app.models.Invitations.GetForToken(invitationToken)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				v.AddError("invitation_token", "invalid or expired invitation")
+				return nil, nil
+			default:
+				return nil, err
+			}
+		}
+
+		// the invitation was emailed to this address, so it can't be passed on
+		v.Check(strings.EqualFold(invitation.Email, email), "email", "must match the email the invitation was sent to")
+
+		return invitation, nil
+	}
+
+	switch app.config.registration.mode {
+	case registrationInvite:
+		v.AddError("invitation_token", "must be provided, registration is by invitation only")
+	case registrationDomain:
+		domain := emailDomain(email)
+
+		if len(app.config.registration.allowedDomains) > 0 {
+			v.Check(slices.Contains(app.config.registration.allowedDomains, domain), "email", "must be on an allowed domain")
+		}
+
+		v.Check(!app.disposable.Contains(domain), "email", "must not be from a disposable email provider")
+	}
+
+	return nil, nil
+}
+
+// the lowercase part of an email address after the @
+func emailDomain(email string) string {
+	return strings.ToLower(email[strings.LastIndex(email, "@")+1:])
+}
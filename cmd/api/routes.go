@@ -1,6 +1,7 @@
 package main
 
 import (
+	"expvar"
 	"net/http"
 
 	"github.com/julienschmidt/httprouter"
@@ -22,19 +23,82 @@ func (app *application) routes() http.Handler {
 	// healthcheck endpoint
 	r.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthCheckHandler)
 
+	// application metrics
+	r.HandlerFunc(http.MethodGet, "/debug/vars", app.requirePermission("users:manage", expvar.Handler().ServeHTTP))
+
 	// movie endpoints
 	r.HandlerFunc(http.MethodGet, "/v1/movies", app.requirePermission("movies:read", app.listMoviesHandler))
-	r.HandlerFunc(http.MethodPost, "/v1/movies", app.requirePermission("movies:write", app.createMovieHandler))
-	r.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.requirePermission("movies:read", app.showMovieHandler))
-	r.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.requirePermission("movies:write", app.updateMovieHandler))
-	r.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requirePermission("movies:write", app.deleteMovieHandler))
+	r.HandlerFunc(http.MethodPost, "/v1/movies", app.requireAnyPermission(moviesWritePermissions, app.createMovieHandler))
+	r.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.staticSegment(map[string]http.HandlerFunc{
+		"suggest": app.rateLimitRoute(app.config.limiter.suggestRPS, app.config.limiter.suggestBurst, app.requirePermission("movies:read", app.suggestMoviesHandler)),
+		"changes": app.requirePermission("movies:read", app.listMovieChangesHandler),
+	}, app.requirePermission("movies:read", app.showMovieHandler)))
+	r.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.requireAnyPermission(moviesWritePermissions, app.updateMovieHandler))
+	r.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requireAnyPermission(moviesWritePermissions, app.deleteMovieHandler))
+
+	// movie collaborator endpoints, movies:write:own only reaches the user's own movies
+	r.HandlerFunc(http.MethodGet, "/v1/movies/:id/collaborators", app.requireAnyPermission(moviesWritePermissions, app.listMovieCollaboratorsHandler))
+	r.HandlerFunc(http.MethodPost, "/v1/movies/:id/collaborators", app.requireAnyPermission(moviesWritePermissions, app.addMovieCollaboratorHandler))
+	r.HandlerFunc(http.MethodDelete, "/v1/movies/:id/collaborators/:user_id", app.requireAnyPermission(moviesWritePermissions, app.removeMovieCollaboratorHandler))
 
 	// user endpoints
 	r.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
 	r.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
+	r.HandlerFunc(http.MethodPut, "/v1/users/password", app.updateUserPasswordHandler)
+	r.HandlerFunc(http.MethodPut, "/v1/users/email", app.confirmEmailChangeHandler)
+	r.HandlerFunc(http.MethodPut, "/v1/users/restored", app.restoreUserHandler)
+	r.HandlerFunc(http.MethodPut, "/v1/users/unlocked", app.unlockUserHandler)
+
+	// current user endpoints
+	r.HandlerFunc(http.MethodGet, "/v1/users/me", app.requireSessionAuth(app.requireUserRecord(app.showCurrentUserHandler)))
+	r.HandlerFunc(http.MethodPatch, "/v1/users/me", app.requireSessionAuth(app.requireUserRecord(app.updateCurrentUserHandler)))
+	r.HandlerFunc(http.MethodDelete, "/v1/users/me", app.requireSessionAuth(app.requireUserRecord(app.deleteCurrentUserHandler)))
+	r.HandlerFunc(http.MethodGet, "/v1/users/me/export", app.requireSessionAuth(app.requireUserRecord(app.exportCurrentUserHandler)))
+	r.HandlerFunc(http.MethodPost, "/v1/users/me/email", app.requiredActivatedUser(app.requireSessionAuth(app.requireUserRecord(app.requestEmailChangeHandler))))
+	r.HandlerFunc(http.MethodGet, "/v1/users/me/sessions", app.requireSessionAuth(app.listSessionsHandler))
+	r.HandlerFunc(http.MethodDelete, "/v1/users/me/sessions", app.requireSessionAuth(app.deleteAllSessionsHandler))
+	r.HandlerFunc(http.MethodDelete, "/v1/users/me/sessions/:id", app.requireSessionAuth(app.deleteSessionHandler))
+	r.HandlerFunc(http.MethodGet, "/v1/users/me/api-keys", app.requireSessionAuth(app.listAPIKeysHandler))
+	r.HandlerFunc(http.MethodPost, "/v1/users/me/api-keys", app.requiredActivatedUser(app.requireSessionAuth(app.createAPIKeyHandler)))
+	r.HandlerFunc(http.MethodDelete, "/v1/users/me/api-keys/:id", app.requireSessionAuth(app.deleteAPIKeyHandler))
+	r.HandlerFunc(http.MethodPost, "/v1/users/me/totp", app.requiredActivatedUser(app.requireSessionAuth(app.requireUserRecord(app.beginTOTPHandler))))
+	r.HandlerFunc(http.MethodPut, "/v1/users/me/totp/confirmed", app.requiredActivatedUser(app.requireSessionAuth(app.confirmTOTPHandler)))
+	r.HandlerFunc(http.MethodDelete, "/v1/users/me/totp", app.requireSessionAuth(app.requireUserRecord(app.disableTOTPHandler)))
+	r.HandlerFunc(http.MethodGet, "/v1/users/me/security-events", app.requireSessionAuth(app.listCurrentUserSecurityEventsHandler))
+
+	// admin endpoints
+	r.HandlerFunc(http.MethodGet, "/v1/admin/roles", app.requirePermission("users:manage", app.listRolesHandler))
+	r.HandlerFunc(http.MethodPost, "/v1/admin/roles", app.requirePermission("users:manage", app.createRoleHandler))
+	r.HandlerFunc(http.MethodGet, "/v1/admin/grants", app.requirePermission("users:manage", app.listGrantsHandler))
+	r.HandlerFunc(http.MethodGet, "/v1/admin/invitations", app.requirePermission("users:manage", app.listInvitationsHandler))
+	r.HandlerFunc(http.MethodPost, "/v1/admin/invitations", app.requirePermission("users:manage", app.createInvitationHandler))
+	r.HandlerFunc(http.MethodDelete, "/v1/admin/invitations/:id", app.requirePermission("users:manage", app.deleteInvitationHandler))
+	r.HandlerFunc(http.MethodGet, "/v1/admin/security-events", app.requirePermission("users:manage", app.listSecurityEventsHandler))
+	r.HandlerFunc(http.MethodGet, "/v1/admin/users", app.requirePermission("users:manage", app.listUsersHandler))
+	r.HandlerFunc(http.MethodGet, "/v1/admin/users/:id", app.requirePermission("users:manage", app.showUserHandler))
+	r.HandlerFunc(http.MethodPut, "/v1/admin/users/:id/activated", app.requirePermission("users:manage", app.adminActivateUserHandler))
+	r.HandlerFunc(http.MethodPut, "/v1/admin/users/:id/deactivated", app.requirePermission("users:manage", app.adminDeactivateUserHandler))
+	r.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/password-reset", app.requirePermission("users:manage", app.adminForcePasswordResetHandler))
+	r.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/sessions", app.requirePermission("users:manage", app.adminDeleteUserSessionsHandler))
+	r.HandlerFunc(http.MethodGet, "/v1/admin/users/:id/grants", app.requirePermission("users:manage", app.showUserGrantsHandler))
+	r.HandlerFunc(http.MethodPut, "/v1/admin/users/:id/roles/:name", app.requirePermission("users:manage", app.assignRoleHandler))
+	r.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/roles/:name", app.requirePermission("users:manage", app.revokeRoleHandler))
+	r.HandlerFunc(http.MethodPut, "/v1/admin/users/:id/permissions/:code", app.requirePermission("users:manage", app.grantPermissionHandler))
+	r.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/permissions/:code", app.requirePermission("users:manage", app.revokePermissionHandler))
 
 	// token authentication
 	r.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	r.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication", app.requireSessionAuth(app.deleteAuthenticationTokenHandler))
+	r.HandlerFunc(http.MethodPost, "/v1/tokens/refresh", app.refreshAuthenticationTokenHandler)
+	r.HandlerFunc(http.MethodPost, "/v1/tokens/mfa", app.createMFAAuthenticationTokenHandler)
+	r.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", app.createPasswordResetTokenHandler)
+	r.HandlerFunc(http.MethodPost, "/v1/tokens/activation", app.createActivationTokenHandler)
+
+	// passwordless login, disabled unless MAGIC_LINK_ENABLED is set
+	if app.config.magicLink.enabled {
+		r.HandlerFunc(http.MethodPost, "/v1/tokens/magic-link", app.createMagicLinkTokenHandler)
+		r.HandlerFunc(http.MethodPost, "/v1/tokens/magic-link/authentication", app.createMagicLinkAuthenticationTokenHandler)
+	}
 
 	// return router instance and use middlewares
 	return app.recoverPanic(
@@ -42,3 +106,19 @@ func (app *application) routes() http.Handler {
 			app.rateLimit(
 				app.authenticate(r))))
 }
+
+// httprouter doesn't allow static routes (i.e. /v1/movies/suggest) next to a
+// wildcard in the same position (/v1/movies/:id), so static segments are
+// matched here against the :id param before falling through to next
+func (app *application) staticSegment(static map[string]http.HandlerFunc, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := httprouter.ParamsFromContext(r.Context())
+
+		if handler, ok := static[params.ByName("id")]; ok {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/V4N1LLA-1CE/movie-db-api/internal/data"
+	"github.com/V4N1LLA-1CE/movie-db-api/internal/validator"
+)
+
+// adds an entry to the security audit log, userID is 0 when the user isn't
+// known. The write happens in the background and a failure is only logged,
+// it shouldn't change the outcome of the request being audited
+func (app *application) recordSecurityEvent(r *http.Request, eventType, outcome string, userID int64, details map[string]string) {
+	event := &data.SecurityEvent{
+		Event:     eventType,
+		Outcome:   outcome,
+		IP:        app.clientIP(r),
+		UserAgent: data.CleanUserAgent(r.UserAgent()),
+		Details:   details,
+	}
+
+	if userID != 0 {
+		event.UserID = &userID
+	}
+
+	// someone acting on another user's account, i.e. an admin granting a role
+	// the user isn't in the context yet while authenticate is still running
+	if actor, ok := r.Context().Value(userContextKey).(*data.User); ok && !actor.IsAnon() && actor.ID != userID {
+		actorID := actor.ID
+		event.ActorID = &actorID
+	}
+
+	app.background(func() {
+		err := app.models.SecurityEvents.Insert(event)
+		if err != nil {
+			app.logger.Error(err.Error(), "event", eventType, "outcome", outcome, "user_id", userID)
+		}
+	})
+}
+
+// a request that couldn't be authenticated, successful ones aren't recorded
+// since that would be every request. Anyone can send a bad token without
+// limit, so failures past a few per IP are dropped rather than filling the log
+func (app *application) recordAuthenticationFailure(r *http.Request, userID int64, reason string) {
+	if !app.authFailureLimiter.allow(app.clientIP(r)) {
+		return
+	}
+
+	app.recordSecurityEvent(r, data.EventAuthentication, data.OutcomeFailure, userID, map[string]string{"reason": reason})
+}
+
+// GET /v1/users/me/security-events
+func (app *application) listCurrentUserSecurityEventsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	v := validator.New()
+
+	search, filters := app.readSecurityEventSearch(r.URL.Query(), v)
+	search.UserID = &user.ID
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	app.writeSecurityEvents(w, r, search, filters)
+}
+
+// GET /v1/admin/security-events
+func (app *application) listSecurityEventsHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+
+	v := validator.New()
+
+	search, filters := app.readSecurityEventSearch(qs, v)
+	search.IP = app.readString(qs, "ip", "")
+
+	if s := app.readString(qs, "user_id", ""); s != "" {
+		userID, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || userID < 1 {
+			v.AddError("user_id", "must be a positive integer value")
+		} else {
+			search.UserID = &userID
+		}
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	app.writeSecurityEvents(w, r, search, filters)
+}
+
+// reads the filters both security event lists share, newest first by default
+func (app *application) readSecurityEventSearch(qs url.Values, v *validator.Validator) (data.SecurityEventSearch, data.Filters) {
+	var search data.SecurityEventSearch
+
+	search.Event = app.readString(qs, "event", "")
+	search.Outcome = app.readString(qs, "outcome", "")
+	search.After = app.readTime(qs, "after", v)
+	search.Before = app.readTime(qs, "before", v)
+
+	if search.Outcome != "" {
+		v.Check(validator.PermittedValue(search.Outcome, data.OutcomeSuccess, data.OutcomeFailure), "outcome", "must be success or failure")
+	}
+
+	filters := data.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         app.readString(qs, "sort", "-id"),
+		SortSafeList: []string{"id", "-id"},
+	}
+
+	data.ValidateFilters(v, filters)
+
+	return search, filters
+}
+
+func (app *application) writeSecurityEvents(w http.ResponseWriter, r *http.Request, search data.SecurityEventSearch, filters data.Filters) {
+	events, metadata, err := app.models.SecurityEvents.GetAll(search, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"security_events": events, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -55,6 +55,28 @@ func (app *application) serve() error {
 		shutdownErr <- nil
 	}()
 
+	// remove accounts that are past their deletion grace period
+	go app.purgeDeletedUsers(time.Hour)
+	go app.purgeExpiredTokens(time.Hour)
+	go app.purgeLoginFailures(time.Hour)
+
+	if app.config.securityEvents.retention > 0 {
+		go app.purgeSecurityEvents(time.Hour)
+	}
+
+	// batch last used times instead of writing on every request
+	go app.flushLastUsed(time.Minute)
+
+	// signed access tokens are checked against an in-memory deny list
+	if app.keyset != nil {
+		go app.refreshSessionDenylist(10 * time.Second)
+	}
+
+	// drop cached permissions when they're changed by another instance
+	if app.config.permissions.listen {
+		go app.listenPermissionChanges(5 * time.Second)
+	}
+
 	// start http server
 	app.logger.Info("starting server...", "addr", srv.Addr, "env", app.config.env)
 
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/V4N1LLA-1CE/movie-db-api/internal/data"
+	"github.com/google/uuid"
+)
+
+// DELETE /v1/tokens/authentication
+func (app *application) deleteAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var err error
+
+	// signed tokens are revoked through their session, which goes on the
+	// deny list here straight away rather than on the next sync
+	if claims := app.contextGetClaims(r); claims != nil {
+		familyID, _ := uuid.Parse(claims.SessionID)
+
+		err = app.models.Tokens.DeleteSessionFamily(familyID)
+		if err == nil {
+			app.denylist.add(familyID, time.Now())
+		}
+	} else {
+		err = app.models.Tokens.DeleteSession(app.contextGetToken(r))
+	}
+
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "you have been logged out"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// GET /v1/users/me/sessions
+func (app *application) listSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	// signed tokens identify the current session by family instead
+	var currentFamily uuid.UUID
+	if claims := app.contextGetClaims(r); claims != nil {
+		currentFamily, _ = uuid.Parse(claims.SessionID)
+	}
+
+	sessions, err := app.models.Tokens.GetSessionsForUser(user.ID, app.contextGetToken(r), currentFamily)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"sessions": sessions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// DELETE /v1/users/me/sessions/:id
+func (app *application) deleteSessionHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	id, err := app.readIdParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	// scoped to the user so other people's sessions look like they don't exist
+	err = app.models.Tokens.DeleteSessionForUser(id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "session successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// DELETE /v1/users/me/sessions
+// logs out everywhere, including the session making the request
+func (app *application) deleteAllSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	err := app.models.Tokens.DeleteAllSessionsForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "all sessions successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sync"
+)
+
+// a database/sql driver that records the queries it's given and answers each
+// one with a single canned row, enough to exercise handlers without postgres
+type recordingDB struct {
+	mu      sync.Mutex
+	queries []recordedQuery
+	columns []string
+	row     []driver.Value
+}
+
+type recordedQuery struct {
+	query string
+	args  []driver.Value
+}
+
+// opens a *sql.DB that answers every query with row
+func newRecordingDB(columns []string, row ...driver.Value) (*sql.DB, *recordingDB) {
+	rec := &recordingDB{columns: columns, row: row}
+	return sql.OpenDB(rec), rec
+}
+
+func (rec *recordingDB) Connect(context.Context) (driver.Conn, error) {
+	return &recordingConn{rec: rec}, nil
+}
+
+func (rec *recordingDB) Driver() driver.Driver {
+	return nil
+}
+
+func (rec *recordingDB) lastQuery() recordedQuery {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	if len(rec.queries) == 0 {
+		return recordedQuery{}
+	}
+
+	return rec.queries[len(rec.queries)-1]
+}
+
+type recordingConn struct {
+	rec *recordingDB
+}
+
+func (c *recordingConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("recordingConn: prepared statements aren't supported")
+}
+
+func (c *recordingConn) Close() error {
+	return nil
+}
+
+func (c *recordingConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("recordingConn: transactions aren't supported")
+}
+
+// values are recorded the way a real driver would send them, Valuers are
+// resolved and everything else is passed through like pgx does
+func (c *recordingConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if valuer, ok := nv.Value.(driver.Valuer); ok {
+		v, err := valuer.Value()
+		if err != nil {
+			return err
+		}
+		nv.Value = v
+	}
+
+	return nil
+}
+
+func (c *recordingConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+
+	c.rec.mu.Lock()
+	c.rec.queries = append(c.rec.queries, recordedQuery{query: query, args: values})
+	c.rec.mu.Unlock()
+
+	return &recordingRows{columns: c.rec.columns, row: c.rec.row}, nil
+}
+
+type recordingRows struct {
+	columns []string
+	row     []driver.Value
+	done    bool
+}
+
+func (r *recordingRows) Columns() []string {
+	return r.columns
+}
+
+func (r *recordingRows) Close() error {
+	return nil
+}
+
+func (r *recordingRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+
+	copy(dest, r.row)
+	return nil
+}
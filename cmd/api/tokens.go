@@ -3,9 +3,11 @@ package main
 import (
 	"errors"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/V4N1LLA-1CE/movie-db-api/internal/data"
+	"github.com/V4N1LLA-1CE/movie-db-api/internal/jwt"
 	"github.com/V4N1LLA-1CE/movie-db-api/internal/validator"
 )
 
@@ -25,18 +27,35 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 	// validate email and password
 	v := validator.New()
 	data.ValidateEmail(v, input.Email)
-	data.ValidatePasswordPlaintext(v, input.Email)
+	data.ValidatePasswordPlaintext(v, input.Password)
 
 	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
+	// emails and IPs with too many failed logins have to wait, or are locked out
+	if !app.checkLoginThrottle(w, r, input.Email) {
+		return
+	}
+
 	// check if user exists
 	user, err := app.models.Users.GetByEmail(input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
+			// take as long as a wrong password would so timing doesn't give
+			// away which emails have accounts
+			data.DummyPasswordMatch(input.Password)
+
+			app.recordSecurityEvent(r, data.EventLogin, data.OutcomeFailure, 0, map[string]string{"email": input.Email, "reason": "unknown email"})
+
+			err = app.recordLoginFailure(r, input.Email, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+
 			app.invalidCredentialsResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
@@ -52,22 +71,362 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 	}
 
 	if !match {
+		app.recordSecurityEvent(r, data.EventLogin, data.OutcomeFailure, user.ID, map[string]string{"reason": "wrong password"})
+
+		err = app.recordLoginFailure(r, input.Email, user)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
 		app.invalidCredentialsResponse(w, r)
 		return
 	}
 
-	// if password correct, generate new token with 24hrs expiry time with
-	// 'authentication' scope
-	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+	err = app.models.LoginFailures.Reset(input.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// hashes from an older policy (i.e. bcrypt) are upgraded while the
+	// plaintext is at hand, a failure here shouldn't stop the login
+	if user.Password.NeedsRehash() {
+		err = app.rehashPassword(user, input.Password)
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+	}
+
+	if user.IsDeactivated() {
+		app.recordSecurityEvent(r, data.EventLogin, data.OutcomeFailure, user.ID, map[string]string{"reason": "account deactivated"})
+		app.deactivatedAccountResponse(w, r)
+		return
+	}
+
+	// accounts waiting to be deleted have to be restored before logging in again
+	if user.DeletionScheduledAt != nil {
+		app.recordSecurityEvent(r, data.EventLogin, data.OutcomeFailure, user.ID, map[string]string{"reason": "account scheduled for deletion"})
+		app.deletionScheduledResponse(w, r)
+		return
+	}
+
+	app.completeLogin(w, r, user, data.EventLogin)
+}
+
+// finishes a login once the user has proven who they are with a password or
+// magic link, eventType is what's recorded in the security log
+func (app *application) completeLogin(w http.ResponseWriter, r *http.Request, user *data.User, eventType string) {
+	// with two-factor authentication on, the first factor only gets a short-lived
+	// 'mfa' token which has to be exchanged along with a code at /v1/tokens/mfa
+	totp, err := app.models.TOTP.GetForUser(user.ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
+	if totp.Enabled() {
+		token, err := app.models.Tokens.New(user.ID, 5*time.Minute, data.ScopeMFA)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.recordSecurityEvent(r, eventType, data.OutcomeSuccess, user.ID, map[string]string{"mfa": "required"})
+
+		env := envelope{
+			"message":   "a two-factor authentication code is required, send it along with mfa_token to POST /v1/tokens/mfa",
+			"mfa_token": token,
+		}
+
+		err = app.writeJSON(w, http.StatusAccepted, env, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.recordSecurityEvent(r, eventType, data.OutcomeSuccess, user.ID, nil)
+
+	app.startSession(w, r, user)
+}
+
+func (app *application) rehashPassword(user *data.User, plaintext string) error {
+	oldHash, err := user.Password.Rehash(plaintext)
+	if err != nil {
+		return err
+	}
+
+	return app.models.Users.UpdatePasswordHash(user, oldHash)
+}
+
+// starts a new session with a short-lived 'authentication' token and a
+// 'refresh' token to get new ones with, and sends both to the client
+func (app *application) startSession(w http.ResponseWriter, r *http.Request, user *data.User) {
+	tokens, err := app.models.Tokens.NewSession(user.ID, app.databaseAccessTTL(), app.config.tokens.refreshTTL, app.clientIP(r), r.UserAgent())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if app.keyset != nil {
+		err = app.signAccessToken(user, tokens)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
 	// encode token to json
-	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token}, nil)
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": tokens.Access, "refresh_token": tokens.Refresh}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// POST /v1/tokens/refresh
+func (app *application) refreshAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
+
+	// the old refresh token stops working, reusing it revokes the whole session
+	tokens, err := app.models.Tokens.RotateSession(input.TokenPlaintext, app.databaseAccessTTL(), app.config.tokens.refreshTTL, app.clientIP(r), r.UserAgent())
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRefreshTokenReused):
+			app.logger.Warn("refresh token reused, session revoked", "ip", app.clientIP(r))
+			app.invalidAuthenticationTokenResponse(w, r)
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// signed tokens pick up permission and activation changes on refresh
+	if app.keyset != nil {
+		user, err := app.models.Users.Get(tokens.Refresh.UserID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.signAccessToken(user, tokens)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": tokens.Access, "refresh_token": tokens.Refresh}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// ttl for access tokens stored in the db, 0 when they're signed instead
+func (app *application) databaseAccessTTL() time.Duration {
+	if app.keyset != nil {
+		return 0
+	}
+
+	return app.config.tokens.accessTTL
+}
+
+// sets tokens.Access to a signed access token for the session
+// permissions are baked in, so changes only apply once the token is refreshed
+func (app *application) signAccessToken(user *data.User, tokens *data.SessionTokens) error {
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		return err
+	}
+
+	totp, err := app.models.TOTP.GetForUser(user.ID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	expiry := now.Add(app.config.tokens.accessTTL)
+
+	claims := jwt.Claims{
+		Subject:     user.ID,
+		SessionID:   tokens.Refresh.FamilyID.String(),
+		Activated:   user.Activated,
+		Permissions: permissions,
+		MFA:         totp.Enabled(),
+		IssuedAt:    now.Unix(),
+		ExpiresAt:   expiry.Unix(),
+	}
+
+	signed, err := app.keyset.Sign(claims)
+	if err != nil {
+		return err
+	}
+
+	tokens.Access = &data.Token{
+		Plaintext: signed,
+		UserID:    user.ID,
+		Expiry:    time.Unix(claims.ExpiresAt, 0),
+		Scope:     data.ScopeAuthentication,
+		FamilyID:  tokens.Refresh.FamilyID,
+	}
+
+	return nil
+}
+
+// POST /v1/tokens/password-reset
+func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// respond the same way whether or not the email exists so this endpoint
+	// can't be used to find out which emails have accounts
+	env := envelope{"message": "if an activated account exists for this email, a password reset link will be sent to it"}
+
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			err = app.writeJSON(w, http.StatusAccepted, env, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// only activated accounts can reset their password
+	if user.Activated {
+		// short-lived single use token, any older reset tokens are removed when it's used
+		token, err := app.models.Tokens.New(user.ID, 45*time.Minute, data.ScopePasswordReset)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.background(func() {
+			data := map[string]any{
+				"passwordResetToken": token.Plaintext,
+			}
+
+			err := app.mailer.Send(user.Email, "token_password_reset.tmpl", data)
+			if err != nil {
+				app.logger.Error(err.Error())
+			}
+		})
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// POST /v1/tokens/activation
+func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// limit per address so this can't be used to flood someone's inbox
+	// applied before the lookup so it behaves the same for unknown emails
+	if !app.emailLimiter.allow(strings.ToLower(input.Email)) {
+		app.rateLimitExceededResponse(w, r)
+		return
+	}
+
+	// respond the same way whether or not the email exists so this endpoint
+	// can't be used to find out which emails have accounts
+	env := envelope{"message": "if an account awaiting activation exists for this email, an activation link will be sent to it"}
+
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			err = app.writeJSON(w, http.StatusAccepted, env, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// nothing to do for accounts that are already activated
+	if !user.Activated {
+		// invalidate any activation tokens that were sent before
+		err = app.models.Tokens.DeleteAllForUser(data.ScopeActivation, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.background(func() {
+			data := map[string]any{
+				"activationToken": token.Plaintext,
+			}
+
+			err := app.mailer.Send(user.Email, "token_activation.tmpl", data)
+			if err != nil {
+				app.logger.Error(err.Error())
+			}
+		})
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
 }
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// validation fails before anything touches the db, so no models are needed
+func TestCreateAuthenticationTokenHandlerValidation(t *testing.T) {
+	app := &application{logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+
+	tests := []struct {
+		name       string
+		body       string
+		wantFields []string
+	}{
+		{
+			name:       "missing password",
+			body:       `{"email": "alice@example.com", "password": ""}`,
+			wantFields: []string{"password"},
+		},
+		{
+			name:       "short password",
+			body:       `{"email": "alice@example.com", "password": "pa55"}`,
+			wantFields: []string{"password"},
+		},
+		{
+			name:       "invalid email",
+			body:       `{"email": "not-an-email", "password": "pa55word123"}`,
+			wantFields: []string{"email"},
+		},
+		{
+			name:       "both invalid",
+			body:       `{"email": "", "password": ""}`,
+			wantFields: []string{"email", "password"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/v1/tokens/authentication", strings.NewReader(tt.body))
+
+			app.createAuthenticationTokenHandler(rr, r)
+
+			if rr.Code != http.StatusUnprocessableEntity {
+				t.Fatalf("status = %d, want %d", rr.Code, http.StatusUnprocessableEntity)
+			}
+
+			var resp struct {
+				Error map[string]string `json:"error"`
+			}
+
+			err := json.NewDecoder(rr.Body).Decode(&resp)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(resp.Error) != len(tt.wantFields) {
+				t.Errorf("errors = %v, want errors for %v", resp.Error, tt.wantFields)
+			}
+
+			for _, field := range tt.wantFields {
+				if _, ok := resp.Error[field]; !ok {
+					t.Errorf("no error for %q in %v", field, resp.Error)
+				}
+			}
+		})
+	}
+}
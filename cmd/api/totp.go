@@ -0,0 +1,317 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/V4N1LLA-1CE/movie-db-api/internal/data"
+	"github.com/V4N1LLA-1CE/movie-db-api/internal/totp"
+	"github.com/V4N1LLA-1CE/movie-db-api/internal/validator"
+)
+
+// shown as the account name in authenticator apps
+const totpIssuer = "MovieDB"
+
+// POST /v1/users/me/totp
+// starts enrolment, the secret isn't used until it's confirmed with a code
+func (app *application) beginTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var input struct {
+		CurrentPassword string `json:"current_password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if !app.checkCurrentPassword(w, r, v, user, input.CurrentPassword) {
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.TOTP.Begin(user.ID, secret)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrTOTPAlreadyEnabled):
+			v.AddError("totp", "two-factor authentication is already enabled")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := envelope{
+		"totp": envelope{
+			"secret":           totp.EncodeSecret(secret),
+			"provisioning_uri": totp.ProvisioningURI(totpIssuer, user.Email, secret),
+		},
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// PUT /v1/users/me/totp/confirmed
+func (app *application) confirmTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTOTPCode(v, input.Code); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	enrolment, err := app.models.TOTP.GetForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if enrolment == nil || enrolment.Enabled() {
+		v.AddError("totp", "there is no two-factor authentication enrolment waiting to be confirmed")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	step, ok := totp.Validate(enrolment.Secret, input.Code, time.Now())
+	if !ok {
+		v.AddError("code", "is invalid or has expired")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	codes, err := app.models.TOTP.Confirm(user.ID, step)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrTOTPAlreadyEnabled):
+			v.AddError("totp", "two-factor authentication is already enabled")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// existing sessions were signed in with just a password
+	err = app.models.Tokens.DeleteAllSessionsForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{
+		"message":        "two-factor authentication enabled, please log in again. Store the recovery codes somewhere safe, they won't be shown again",
+		"recovery_codes": codes,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// DELETE /v1/users/me/totp
+func (app *application) disableTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var input struct {
+		CurrentPassword string `json:"current_password"`
+		Code            string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTOTPCode(v, input.Code); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if !app.checkCurrentPassword(w, r, v, user, input.CurrentPassword) {
+		return
+	}
+
+	enrolment, err := app.models.TOTP.GetForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !enrolment.Enabled() {
+		v.AddError("totp", "two-factor authentication is not enabled")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if app.config.accounts.mfaRequiredForWrite {
+		permissions, err := app.cachedPermissions(user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if slices.ContainsFunc(moviesWritePermissions, permissions.Include) {
+			app.mfaRequiredResponse(w, r)
+			return
+		}
+	}
+
+	ok, err := app.checkSecondFactor(enrolment, input.Code)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !ok {
+		v.AddError("code", "is invalid or has expired")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.TOTP.Delete(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "two-factor authentication disabled"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// POST /v1/tokens/mfa
+// second step of logging in with two-factor authentication
+func (app *application) createMFAAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidateTOTPCode(v, input.Code)
+	v.Check(input.MFAToken != "", "mfa_token", "must be provided")
+	v.Check(data.IsTokenPlaintext(input.MFAToken), "mfa_token", "is not a valid token")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopeMFA, input.MFAToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// a 6 digit code only has a million values, so attempts are limited per user
+	if !app.mfaLimiter.allow(strconv.FormatInt(user.ID, 10)) {
+		app.rateLimitExceededResponse(w, r)
+		return
+	}
+
+	enrolment, err := app.models.TOTP.GetForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// 2fa could have been turned off since the password step
+	if !enrolment.Enabled() {
+		app.recordSecurityEvent(r, data.EventLoginMFA, data.OutcomeFailure, user.ID, map[string]string{"reason": "two-factor authentication disabled"})
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	ok, err := app.checkSecondFactor(enrolment, input.Code)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !ok {
+		app.recordSecurityEvent(r, data.EventLoginMFA, data.OutcomeFailure, user.ID, map[string]string{"reason": "invalid code"})
+
+		v.AddError("code", "is invalid or has expired")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeMFA, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.recordSecurityEvent(r, data.EventLoginMFA, data.OutcomeSuccess, user.ID, nil)
+
+	app.startSession(w, r, user)
+}
+
+// checks a code from the user's authenticator app, or one of their recovery
+// codes, and uses it up so it can't be replayed
+func (app *application) checkSecondFactor(enrolment *data.TOTP, code string) (bool, error) {
+	if step, ok := totp.Validate(enrolment.Secret, code, time.Now()); ok {
+		return app.models.TOTP.UseStep(enrolment.UserID, step)
+	}
+
+	return app.models.TOTP.UseRecoveryCode(enrolment.UserID, code)
+}
+
+// whether the user behind the request satisfies MFA_REQUIRED_FOR_WRITE
+func (app *application) mfaSatisfied(r *http.Request, user *data.User) (bool, error) {
+	if claims := app.contextGetClaims(r); claims != nil {
+		return claims.MFA, nil
+	}
+
+	enrolment, err := app.models.TOTP.GetForUser(user.ID)
+	if err != nil {
+		return false, err
+	}
+
+	return enrolment.Enabled(), nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha1"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/V4N1LLA-1CE/movie-db-api/internal/breached"
+)
+
+// builds breached password lists in the format internal/breached reads
+//
+//	go run ./cmd/breachlist -in passwords.txt -out internal/breached/common.txt.gz
+//	go run ./cmd/breachlist -in pwned-passwords-sha1-ordered-by-count-v8.txt -format sha1 -limit 1000000 -out breached.txt.gz
+//
+// plain input has one password per line, sha1 input has a full SHA-1 hash per
+// line with an optional :count, like the Have I Been Pwned downloads
+func main() {
+	in := flag.String("in", "", "input file, defaults to stdin")
+	out := flag.String("out", "", "output file, gzip compressed if it ends in .gz, defaults to stdout")
+	format := flag.String("format", "plain", "input format, plain or sha1")
+	limit := flag.Int("limit", 0, "only read the first n entries, 0 for all of them")
+	flag.Parse()
+
+	var r io.Reader = os.Stdin
+	if *in != "" {
+		f, err := os.Open(*in)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	hashes, err := readHashes(r, *format, *limit)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	list := breached.New(hashes...)
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if strings.HasSuffix(*out, ".gz") {
+		zw, err := gzip.NewWriterLevel(w, gzip.BestCompression)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer zw.Close()
+		w = zw
+	}
+
+	err = list.Write(w)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Fprintf(os.Stderr, "wrote %d hashes\n", list.Len())
+}
+
+func readHashes(r io.Reader, format string, limit int) ([][sha1.Size]byte, error) {
+	var hashes [][sha1.Size]byte
+
+	scanner := bufio.NewScanner(r)
+	line := 0
+
+	for scanner.Scan() {
+		line++
+
+		text := scanner.Text()
+
+		switch format {
+		case "plain":
+			if text == "" {
+				continue
+			}
+
+			hashes = append(hashes, sha1.Sum([]byte(text)))
+
+		case "sha1":
+			encoded, _, _ := strings.Cut(strings.TrimSpace(text), ":")
+			if encoded == "" {
+				continue
+			}
+
+			var hash [sha1.Size]byte
+
+			if len(encoded) != 2*sha1.Size {
+				return nil, fmt.Errorf("line %d: expected a 40 hex digit SHA-1 hash", line)
+			}
+
+			_, err := hex.Decode(hash[:], []byte(encoded))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+
+			hashes = append(hashes, hash)
+
+		default:
+			return nil, fmt.Errorf("unsupported format %q, expected plain or sha1", format)
+		}
+
+		if limit > 0 && len(hashes) >= limit {
+			break
+		}
+	}
+
+	return hashes, scanner.Err()
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/V4N1LLA-1CE/movie-db-api/internal/data"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/joho/godotenv"
+)
+
+// manages the keys stored tokens are hashed with
+//
+//	go run ./cmd/tokenkeys -kid 2024-06 generate
+//	go run ./cmd/tokenkeys -keys tokens.json status
+//	go run ./cmd/tokenkeys -keys tokens.json -dry-run retire
+//
+// generate prints a new key entry to add to the TOKEN_PEPPER_FILE, status
+// counts the stored tokens and invitations for each key and retire deletes
+// every one hashed with a key that's no longer in the file, including legacy
+// sha256 ones.
+// PG_DSN and TOKEN_PEPPER_FILE are read from .env when the flags aren't given
+func main() {
+	// .env is optional here, the flags can be used instead
+	_ = godotenv.Load()
+
+	dsn := flag.String("dsn", os.Getenv("PG_DSN"), "postgres dsn")
+	keysFile := flag.String("keys", os.Getenv("TOKEN_PEPPER_FILE"), "token key file")
+	kid := flag.String("kid", "", "key id for generate")
+	dryRun := flag.Bool("dry-run", false, "only show what retire would delete")
+	flag.Parse()
+
+	switch cmd := flag.Arg(0); cmd {
+	case "generate":
+		err := generate(*kid)
+		if err != nil {
+			log.Fatal(err)
+		}
+	case "status", "retire":
+		keys, err := data.LoadTokenKeys(*keysFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		db, err := openDB(*dsn)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer db.Close()
+
+		tokens := data.NewModels(db).Tokens
+
+		if cmd == "status" {
+			err = status(tokens, keys)
+		} else {
+			err = retire(tokens, keys, *dryRun)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	default:
+		log.Fatalf("unknown command %q, expected generate, status or retire", cmd)
+	}
+}
+
+func generate(kid string) error {
+	if !data.TokenKeyIDRX.MatchString(kid) {
+		return errors.New("-kid must be 1 to 32 letters, digits or dashes")
+	}
+
+	key, err := data.GenerateTokenKey()
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(map[string]string{"kid": kid, "key": key})
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(string(b))
+	return nil
+}
+
+func status(tokens data.TokenModel, keys *data.TokenKeys) error {
+	counts, err := tokens.CountByKey()
+	if err != nil {
+		return err
+	}
+
+	for _, id := range keys.IDs() {
+		label := id
+		if id == keys.Active() {
+			label += " (active)"
+		}
+		fmt.Printf("%-40s %d\n", label, counts[id])
+	}
+
+	for _, id := range retiredKeys(counts, keys) {
+		fmt.Printf("%-40s %d\n", describeRetired(id), counts[id])
+	}
+
+	return nil
+}
+
+func retire(tokens data.TokenModel, keys *data.TokenKeys, dryRun bool) error {
+	if dryRun {
+		counts, err := tokens.CountByKey()
+		if err != nil {
+			return err
+		}
+
+		for _, id := range retiredKeys(counts, keys) {
+			fmt.Printf("would delete %d tokens and invitations for %s\n", counts[id], describeRetired(id))
+		}
+
+		return nil
+	}
+
+	deleted, err := tokens.DeleteForRetiredKeys(keys.IDs())
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("deleted %d tokens and invitations\n", deleted)
+	return nil
+}
+
+// key ids that still have tokens or invitations but aren't in the key file
+func retiredKeys(counts map[string]int64, keys *data.TokenKeys) []string {
+	ids := []string{}
+	for id := range counts {
+		if !slices.Contains(keys.IDs(), id) {
+			ids = append(ids, id)
+		}
+	}
+
+	slices.Sort(ids)
+
+	return ids
+}
+
+func describeRetired(id string) string {
+	if id == "" {
+		return "legacy sha256 (retired)"
+	}
+
+	return id + " (retired)"
+}
+
+func openDB(dsn string) (*sql.DB, error) {
+	if dsn == "" {
+		return nil, errors.New("-dsn or PG_DSN must be set")
+	}
+
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = db.PingContext(ctx)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
@@ -0,0 +1,3 @@
+-- btree index for prefix matching on title (LIKE 'abc%')
+-- text_pattern_ops allows LIKE to use the index regardless of collation
+CREATE INDEX IF NOT EXISTS idx_movies_title_prefix ON movies (lower(title) text_pattern_ops);
@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS movie_tombstones;
+
+DROP INDEX IF EXISTS idx_movies_change_seq;
+
+ALTER TABLE movies DROP COLUMN IF EXISTS change_seq;
+ALTER TABLE movies DROP COLUMN IF EXISTS created_seq;
+ALTER TABLE movies DROP COLUMN IF EXISTS updated_at;
+
+DROP SEQUENCE IF EXISTS movies_change_seq;
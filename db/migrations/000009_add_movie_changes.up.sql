@@ -0,0 +1,26 @@
+-- monotonic sequence shared by movie writes and deletes
+-- every insert, update and delete takes the next value so clients can
+-- ask for everything that changed after the last value they saw
+CREATE SEQUENCE IF NOT EXISTS movies_change_seq;
+
+ALTER TABLE movies ADD COLUMN updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW();
+ALTER TABLE movies ADD COLUMN created_seq bigint;
+ALTER TABLE movies ADD COLUMN change_seq bigint;
+
+-- backfill existing movies so they show up as created in a full sync
+UPDATE movies SET updated_at = created_at, change_seq = nextval('movies_change_seq');
+UPDATE movies SET created_seq = change_seq;
+
+ALTER TABLE movies ALTER COLUMN created_seq SET NOT NULL;
+ALTER TABLE movies ALTER COLUMN change_seq SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_movies_change_seq ON movies (change_seq);
+
+-- record of deleted movies so offline clients can remove them
+CREATE TABLE IF NOT EXISTS movie_tombstones (
+  movie_id bigint PRIMARY KEY,
+  deleted_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  change_seq bigint NOT NULL DEFAULT nextval('movies_change_seq')
+);
+
+CREATE INDEX IF NOT EXISTS idx_movie_tombstones_change_seq ON movie_tombstones (change_seq);
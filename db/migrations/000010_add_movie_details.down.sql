@@ -0,0 +1,11 @@
+DROP INDEX IF EXISTS idx_movies_original_language;
+DROP INDEX IF EXISTS idx_movies_countries;
+DROP INDEX IF EXISTS idx_movies_plot_trigram;
+
+ALTER TABLE movies DROP CONSTRAINT IF EXISTS countries_length_check;
+
+ALTER TABLE movies DROP COLUMN IF EXISTS homepage;
+ALTER TABLE movies DROP COLUMN IF EXISTS countries;
+ALTER TABLE movies DROP COLUMN IF EXISTS original_language;
+ALTER TABLE movies DROP COLUMN IF EXISTS tagline;
+ALTER TABLE movies DROP COLUMN IF EXISTS plot;
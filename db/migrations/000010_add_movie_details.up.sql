@@ -0,0 +1,13 @@
+-- descriptive fields, all optional so existing movies stay valid
+ALTER TABLE movies ADD COLUMN plot text NOT NULL DEFAULT '';
+ALTER TABLE movies ADD COLUMN tagline text NOT NULL DEFAULT '';
+ALTER TABLE movies ADD COLUMN original_language text NOT NULL DEFAULT '';
+ALTER TABLE movies ADD COLUMN countries text[] NOT NULL DEFAULT '{}';
+ALTER TABLE movies ADD COLUMN homepage text NOT NULL DEFAULT '';
+
+ALTER TABLE movies ADD CONSTRAINT countries_length_check CHECK (cardinality(countries) <= 10);
+
+-- indexes for searching plot text and filtering by language/country
+CREATE INDEX IF NOT EXISTS idx_movies_plot_trigram ON movies USING gin (lower(plot) gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_movies_countries ON movies USING gin (countries);
+CREATE INDEX IF NOT EXISTS idx_movies_original_language ON movies (original_language);
@@ -0,0 +1,2 @@
+-- address a user has asked to change to, only swapped into email once confirmed
+ALTER TABLE users ADD COLUMN pending_email citext NOT NULL DEFAULT '';
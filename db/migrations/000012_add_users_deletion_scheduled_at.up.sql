@@ -0,0 +1,7 @@
+-- set when a user asks to delete their account, the row is hard deleted once
+-- this time has passed and everything referencing users cascades with it
+ALTER TABLE users ADD COLUMN deletion_scheduled_at timestamp(0) with time zone;
+
+-- only a handful of rows are ever pending so keep the index small
+CREATE INDEX IF NOT EXISTS users_deletion_scheduled_at_idx ON users (deletion_scheduled_at)
+  WHERE deletion_scheduled_at IS NOT NULL;
@@ -0,0 +1,9 @@
+-- session details for authentication tokens so users can see and revoke them
+-- hash stays the primary key, id is a stable handle that is safe to expose
+ALTER TABLE tokens ADD COLUMN id bigserial UNIQUE;
+ALTER TABLE tokens ADD COLUMN created_at timestamp(0) with time zone NOT NULL DEFAULT NOW();
+ALTER TABLE tokens ADD COLUMN last_used_at timestamp(0) with time zone;
+ALTER TABLE tokens ADD COLUMN ip text NOT NULL DEFAULT '';
+ALTER TABLE tokens ADD COLUMN user_agent text NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS tokens_user_id_scope_idx ON tokens (user_id, scope);
@@ -0,0 +1,7 @@
+-- access and refresh tokens issued from the same login share a family
+-- refresh tokens are marked as used on rotation rather than deleted so
+-- a replayed one can be spotted and its whole family revoked
+ALTER TABLE tokens ADD COLUMN family_id uuid NOT NULL DEFAULT uuid_generate_v4();
+ALTER TABLE tokens ADD COLUMN used_at timestamp(0) with time zone;
+
+CREATE INDEX IF NOT EXISTS tokens_family_id_idx ON tokens (family_id);
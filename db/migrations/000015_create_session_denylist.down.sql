@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS tokens_deny_deleted_sessions ON tokens;
+DROP FUNCTION IF EXISTS deny_deleted_sessions();
+DROP TABLE IF EXISTS session_denylist;
@@ -0,0 +1,30 @@
+-- sessions revoked before their signed access tokens expire
+-- signed tokens are checked without touching the tokens table, so instead
+-- the app keeps a copy of this list in memory and rejects tokens whose
+-- session is on it
+CREATE TABLE IF NOT EXISTS session_denylist (
+  family_id uuid PRIMARY KEY,
+  revoked_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS session_denylist_revoked_at_idx ON session_denylist (revoked_at);
+
+-- any live session token that gets deleted (logout, revoking a session,
+-- refresh token reuse, password reset, account deletion cascades) puts its
+-- family on the deny list so nothing in the app can forget to
+CREATE OR REPLACE FUNCTION deny_deleted_sessions() RETURNS trigger AS $$
+BEGIN
+  INSERT INTO session_denylist (family_id)
+  SELECT DISTINCT family_id FROM deleted_tokens
+  WHERE scope IN ('authentication', 'refresh') AND expiry > NOW()
+  ON CONFLICT (family_id) DO UPDATE SET revoked_at = NOW();
+
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER tokens_deny_deleted_sessions
+  AFTER DELETE ON tokens
+  REFERENCING OLD TABLE AS deleted_tokens
+  FOR EACH STATEMENT
+  EXECUTE FUNCTION deny_deleted_sessions();
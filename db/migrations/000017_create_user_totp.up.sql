@@ -0,0 +1,18 @@
+-- opt-in TOTP two-factor authentication
+-- confirmed_at stays null until the user proves their authenticator works,
+-- last_used_step stops a code from being replayed inside its window
+CREATE TABLE IF NOT EXISTS user_totp (
+  user_id bigint PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+  secret bytea NOT NULL,
+  confirmed_at timestamp(0) with time zone,
+  last_used_step bigint NOT NULL DEFAULT 0
+);
+
+-- single use codes for when the authenticator is lost, only hashes are kept
+CREATE TABLE IF NOT EXISTS recovery_codes (
+  id bigserial PRIMARY KEY,
+  user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+  hash bytea NOT NULL,
+  used_at timestamp(0) with time zone,
+  UNIQUE (user_id, hash)
+);
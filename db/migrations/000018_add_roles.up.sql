@@ -0,0 +1,45 @@
+-- permission codes have to be unique so they can be looked up by code
+ALTER TABLE permissions ADD CONSTRAINT permissions_code_key UNIQUE (code);
+
+INSERT INTO permissions (code)
+VALUES ('users:manage')
+ON CONFLICT (code) DO NOTHING;
+
+-- roles group permissions, users get the permissions of every role they
+-- hold on top of anything granted to them directly in users_permissions
+CREATE TABLE IF NOT EXISTS roles (
+  id bigserial PRIMARY KEY,
+  name text UNIQUE NOT NULL,
+  description text NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS roles_permissions (
+  role_id bigint NOT NULL REFERENCES roles ON DELETE CASCADE,
+  permission_id bigint NOT NULL REFERENCES permissions ON DELETE CASCADE,
+  PRIMARY KEY(role_id, permission_id)
+);
+
+CREATE TABLE IF NOT EXISTS users_roles (
+  user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+  role_id bigint NOT NULL REFERENCES roles ON DELETE CASCADE,
+  PRIMARY KEY(user_id, role_id)
+);
+
+CREATE INDEX IF NOT EXISTS users_roles_role_id_idx ON users_roles (role_id);
+
+INSERT INTO roles (name, description)
+VALUES
+  ('viewer', 'can browse movies'),
+  ('editor', 'can browse and edit movies'),
+  ('admin', 'can edit movies and manage users, roles and permissions')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO roles_permissions
+SELECT roles.id, permissions.id
+FROM roles
+INNER JOIN permissions ON permissions.code = ANY(CASE roles.name
+  WHEN 'viewer' THEN ARRAY['movies:read']
+  WHEN 'editor' THEN ARRAY['movies:read', 'movies:write']
+  WHEN 'admin' THEN ARRAY['movies:read', 'movies:write', 'users:manage']
+END)
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,5 @@
+-- set by an admin to lock a user out, separate from activated which only
+-- tracks whether the email address was confirmed
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deactivated_at timestamp(0) with time zone;
+
+CREATE INDEX IF NOT EXISTS users_created_at_idx ON users (created_at);
@@ -0,0 +1,8 @@
+-- failed logins are tracked by the email that was tried rather than by user
+-- so unknown emails are throttled exactly like real ones
+CREATE TABLE IF NOT EXISTS login_failures (
+  email citext PRIMARY KEY,
+  failures integer NOT NULL DEFAULT 0,
+  last_failed_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  locked_until timestamp(0) with time zone
+);
@@ -0,0 +1,31 @@
+-- lets api instances drop cached permissions when grants change
+-- the payload is the affected user's id, or * when a role changed and
+-- every user holding it could be affected
+CREATE OR REPLACE FUNCTION notify_permissions_changed() RETURNS trigger AS $$
+BEGIN
+  IF TG_TABLE_NAME = 'roles_permissions' THEN
+    PERFORM pg_notify('permissions_changed', '*');
+  ELSIF TG_OP = 'DELETE' THEN
+    PERFORM pg_notify('permissions_changed', OLD.user_id::text);
+  ELSE
+    PERFORM pg_notify('permissions_changed', NEW.user_id::text);
+  END IF;
+
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER users_permissions_notify
+  AFTER INSERT OR UPDATE OR DELETE ON users_permissions
+  FOR EACH ROW
+  EXECUTE FUNCTION notify_permissions_changed();
+
+CREATE TRIGGER users_roles_notify
+  AFTER INSERT OR UPDATE OR DELETE ON users_roles
+  FOR EACH ROW
+  EXECUTE FUNCTION notify_permissions_changed();
+
+CREATE TRIGGER roles_permissions_notify
+  AFTER INSERT OR UPDATE OR DELETE ON roles_permissions
+  FOR EACH ROW
+  EXECUTE FUNCTION notify_permissions_changed();
@@ -0,0 +1,5 @@
+DELETE FROM roles WHERE name = 'contributor';
+DROP TABLE IF EXISTS movies_collaborators;
+DELETE FROM permissions WHERE code = 'movies:write:own';
+DROP INDEX IF EXISTS movies_created_by_idx;
+ALTER TABLE movies DROP COLUMN IF EXISTS created_by;
@@ -0,0 +1,31 @@
+-- movies created before this are left without an owner, so only writers
+-- with the full movies:write permission can edit them
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS created_by bigint REFERENCES users ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS movies_created_by_idx ON movies (created_by);
+
+-- movies:write:own only allows editing movies the user created or has been
+-- added to as a collaborator
+INSERT INTO permissions (code)
+VALUES ('movies:write:own')
+ON CONFLICT (code) DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS movies_collaborators (
+  movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+  user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  PRIMARY KEY(movie_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS movies_collaborators_user_id_idx ON movies_collaborators (user_id);
+
+INSERT INTO roles (name, description)
+VALUES ('contributor', 'can browse movies and edit the ones they created or collaborate on')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO roles_permissions
+SELECT roles.id, permissions.id
+FROM roles
+INNER JOIN permissions ON permissions.code IN ('movies:read', 'movies:write:own')
+WHERE roles.name = 'contributor'
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,17 @@
+-- append-only record of authentication and authorisation events
+-- user_id isn't a foreign key so the history outlives deleted accounts,
+-- actor_id is set when someone else (i.e. an admin) acted on the user
+CREATE TABLE IF NOT EXISTS security_events (
+  id bigserial PRIMARY KEY,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  event text NOT NULL,
+  outcome text NOT NULL,
+  user_id bigint,
+  actor_id bigint,
+  ip text NOT NULL DEFAULT '',
+  user_agent text NOT NULL DEFAULT '',
+  details jsonb NOT NULL DEFAULT '{}'
+);
+
+CREATE INDEX IF NOT EXISTS security_events_user_id_idx ON security_events (user_id, created_at);
+CREATE INDEX IF NOT EXISTS security_events_created_at_idx ON security_events (created_at);
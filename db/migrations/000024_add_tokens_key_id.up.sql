@@ -0,0 +1,2 @@
+-- the pepper a token's hash was made with, '' for tokens hashed with plain sha256
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS key_id text NOT NULL DEFAULT '';
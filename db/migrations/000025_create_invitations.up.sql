@@ -0,0 +1,12 @@
+-- invitations are for people without an account yet, so they're kept apart
+-- from tokens which always belong to a user. Permissions are stored as codes
+-- and granted when the invitation is used
+CREATE TABLE IF NOT EXISTS invitations (
+  id bigserial PRIMARY KEY,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  email citext UNIQUE NOT NULL,
+  permissions text[] NOT NULL DEFAULT '{}',
+  invited_by bigint REFERENCES users ON DELETE SET NULL,
+  hash bytea UNIQUE NOT NULL,
+  expiry timestamp(0) with time zone NOT NULL
+);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_movie_tombstones_change_xid_seq;
+DROP INDEX IF EXISTS idx_movies_change_xid_seq;
+ALTER TABLE movie_tombstones DROP COLUMN IF EXISTS change_xid;
+ALTER TABLE movies DROP COLUMN IF EXISTS change_xid;
+ALTER TABLE movies DROP COLUMN IF EXISTS created_xid;
@@ -0,0 +1,15 @@
+-- sequence values are handed out before commit, so ordering changes by them
+-- alone lets a slow transaction commit behind a token a client already has.
+-- changes are ordered by the writing transaction's id first, and sync stops
+-- before the oldest transaction still running
+-- rows from before this get xid 1 so existing tokens keep their position
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS created_xid xid8 NOT NULL DEFAULT '1';
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS change_xid xid8 NOT NULL DEFAULT '1';
+ALTER TABLE movies ALTER COLUMN created_xid SET DEFAULT pg_current_xact_id();
+ALTER TABLE movies ALTER COLUMN change_xid SET DEFAULT pg_current_xact_id();
+
+ALTER TABLE movie_tombstones ADD COLUMN IF NOT EXISTS change_xid xid8 NOT NULL DEFAULT '1';
+ALTER TABLE movie_tombstones ALTER COLUMN change_xid SET DEFAULT pg_current_xact_id();
+
+CREATE INDEX IF NOT EXISTS idx_movies_change_xid_seq ON movies (change_xid, change_seq);
+CREATE INDEX IF NOT EXISTS idx_movie_tombstones_change_xid_seq ON movie_tombstones (change_xid, change_seq);
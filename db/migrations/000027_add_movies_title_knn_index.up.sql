@@ -0,0 +1,3 @@
+-- gist trigram index so typeahead can order by distance (<->) and stop at the
+-- limit, the gin index can filter but not order
+CREATE INDEX IF NOT EXISTS idx_movies_title_trigram_gist ON movies USING gist (lower(title) gist_trgm_ops);
@@ -0,0 +1,5 @@
+-- the pepper an invitation's hash was made with, same as tokens.key_id so
+-- retiring a key removes the invitations it can no longer check. Invitations
+-- from before this can't be told apart from legacy sha256 ones, they expire
+-- soon enough that retire treating them that way is fine
+ALTER TABLE invitations ADD COLUMN IF NOT EXISTS key_id text NOT NULL DEFAULT '';
@@ -0,0 +1,161 @@
+package breached
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+// offline check of passwords against lists of breached passwords
+//
+// lists are stored as SHA-1 hashes split k-anonymity style into a 5 hex digit
+// prefix and 35 hex digit suffix, the same split the Have I Been Pwned range
+// api uses. Files can be gzip compressed and look like
+//
+//	# comments start with a hash
+//	5BAA6:1E4C9B93F3F0682250B6CF8331B7EE68FD8:9545824
+//	7C4A8:D09CA3762AF61E59520943DC26494F8941B:37359195
+//
+// the trailing count is optional and ignored. cmd/breachlist builds them from
+// plaintext lists or the Have I Been Pwned downloads
+
+//go:embed common.txt.gz
+var common []byte
+
+// List is a set of breached password hashes
+type List struct {
+	hashes [][sha1.Size]byte // sorted so lookups can binary search
+}
+
+// Common returns the list of common breached passwords shipped with the binary
+func Common() *List {
+	l, err := Read(bytes.NewReader(common))
+	if err != nil {
+		panic("breached: embedded list is invalid: " + err.Error())
+	}
+
+	return l
+}
+
+// Load reads a list from a local file
+func Load(path string) (*List, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Read(f)
+}
+
+// Read reads a list in the format above, gzip compressed or not
+func Read(r io.Reader) (*List, error) {
+	br := bufio.NewReader(r)
+
+	// gzip magic number
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("breached: %w", err)
+		}
+		defer zr.Close()
+
+		br = bufio.NewReader(zr)
+	}
+
+	var hashes [][sha1.Size]byte
+
+	scanner := bufio.NewScanner(br)
+	line := 0
+
+	for scanner.Scan() {
+		line++
+
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(text, ":", 3)
+		if len(parts) < 2 || len(parts[0]) != 5 || len(parts[1]) != 35 {
+			return nil, fmt.Errorf("breached: line %d: expected <5 hex digit prefix>:<35 hex digit suffix>", line)
+		}
+
+		var hash [sha1.Size]byte
+
+		_, err := hex.Decode(hash[:], []byte(parts[0]+parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("breached: line %d: %w", line, err)
+		}
+
+		hashes = append(hashes, hash)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("breached: %w", err)
+	}
+
+	return New(hashes...), nil
+}
+
+// New returns a list of SHA-1 password hashes
+func New(hashes ...[sha1.Size]byte) *List {
+	hashes = slices.Clone(hashes)
+	slices.SortFunc(hashes, compareHashes)
+
+	return &List{hashes: slices.Compact(hashes)}
+}
+
+// Merge returns a list with the hashes from both lists
+func (l *List) Merge(other *List) *List {
+	return New(append(slices.Clone(l.hashes), other.hashes...)...)
+}
+
+// Len returns the number of hashes in the list
+func (l *List) Len() int {
+	return len(l.hashes)
+}
+
+// Contains reports whether the password, or its lowercase form, is on the list
+func (l *List) Contains(password string) bool {
+	if l.contains(sha1.Sum([]byte(password))) {
+		return true
+	}
+
+	lower := strings.ToLower(password)
+
+	return lower != password && l.contains(sha1.Sum([]byte(lower)))
+}
+
+func (l *List) contains(hash [sha1.Size]byte) bool {
+	_, found := slices.BinarySearchFunc(l.hashes, hash, compareHashes)
+	return found
+}
+
+func compareHashes(a, b [sha1.Size]byte) int {
+	return bytes.Compare(a[:], b[:])
+}
+
+// Write writes the list in the format above, uncompressed
+func (l *List) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	for _, hash := range l.hashes {
+		encoded := strings.ToUpper(hex.EncodeToString(hash[:]))
+
+		_, err := fmt.Fprintf(bw, "%s:%s\n", encoded[:5], encoded[5:])
+		if err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
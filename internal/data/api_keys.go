@@ -0,0 +1,280 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/V4N1LLA-1CE/movie-db-api/internal/validator"
+	"github.com/lib/pq"
+)
+
+// keys look like mdb_<8 char prefix>_<32 char secret>
+// the prefix makes keys easy to spot (i.e. by secret scanners) and identify
+const apiKeyTag = "mdb_"
+
+var lowerBase32 = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+type APIKey struct {
+	ID          int64       `json:"id"`
+	UserID      int64       `json:"-"`
+	Name        string      `json:"name"`
+	Prefix      string      `json:"prefix"`
+	Plaintext   string      `json:"key,omitempty"` // only set when the key is created
+	Hash        []byte      `json:"-"`
+	Permissions Permissions `json:"permissions"`
+	CreatedAt   time.Time   `json:"created_at"`
+	Expiry      *time.Time  `json:"expiry"`
+	LastUsedAt  *time.Time  `json:"last_used_at"`
+}
+
+func generateAPIKey(userID int64, name string, permissions Permissions, expiry *time.Time) (*APIKey, error) {
+	randomBytes := make([]byte, 25)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	// 5 bytes for the prefix, 20 for the secret
+	encoded := lowerBase32.EncodeToString(randomBytes)
+
+	key := &APIKey{
+		UserID:      userID,
+		Name:        name,
+		Prefix:      apiKeyTag + encoded[:8],
+		Permissions: permissions,
+		Expiry:      expiry,
+	}
+
+	key.Plaintext = key.Prefix + "_" + encoded[8:]
+
+	hash := sha256.Sum256([]byte(key.Plaintext))
+	key.Hash = hash[:]
+
+	return key, nil
+}
+
+// looks like a key this api would have handed out
+func IsAPIKeyPlaintext(plaintext string) bool {
+	return strings.HasPrefix(plaintext, apiKeyTag) && len(plaintext) == len(apiKeyTag)+8+1+32
+}
+
+// allowed is the permissions of the user the key is for, a key can only have a subset of them
+func ValidateAPIKey(v *validator.Validator, key *APIKey, allowed Permissions) {
+	v.Check(key.Name != "", "name", "must be provided")
+	v.Check(len(key.Name) <= 100, "name", "must not exceed 100 characters long")
+
+	v.Check(len(key.Permissions) > 0, "permissions", "must contain at least 1 permission")
+	v.Check(validator.Unique(key.Permissions), "permissions", "must not contain duplicate values")
+
+	for _, p := range key.Permissions {
+		if !allowed.Include(p) {
+			v.AddError("permissions", "must only contain permissions you have, "+strconv.Quote(p)+" is not one of them")
+			break
+		}
+	}
+
+	if key.Expiry != nil {
+		v.Check(key.Expiry.After(time.Now()), "expiry", "must be in the future")
+	}
+}
+
+type APIKeyModel struct {
+	DB *sql.DB
+}
+
+// creates and stores a new key, the returned key is the only copy of the plaintext
+func (m *APIKeyModel) New(userID int64, name string, permissions Permissions, expiry *time.Time) (*APIKey, error) {
+	key, err := generateAPIKey(userID, name, permissions, expiry)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := `INSERT INTO api_keys (user_id, name, prefix, hash, permissions, expiry)
+  VALUES ($1, $2, $3, $4, $5, $6)
+  RETURNING id, created_at`
+
+	args := []any{key.UserID, key.Name, key.Prefix, key.Hash, pq.Array([]string(key.Permissions)), key.Expiry}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, stmt, args...).Scan(&key.ID, &key.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// returns all of the user's keys including expired ones, newest first
+func (m *APIKeyModel) GetAllForUser(userID int64) ([]*APIKey, error) {
+	stmt := `SELECT id, user_id, name, prefix, permissions, created_at, expiry, last_used_at
+  FROM api_keys
+  WHERE user_id = $1
+  ORDER BY id DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+
+	for rows.Next() {
+		var key APIKey
+
+		err := rows.Scan(
+			&key.ID,
+			&key.UserID,
+			&key.Name,
+			&key.Prefix,
+			pq.Array((*[]string)(&key.Permissions)),
+			&key.CreatedAt,
+			&key.Expiry,
+			&key.LastUsedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, &key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// returns the unexpired key for a plaintext along with the user it belongs to
+func (m *APIKeyModel) GetForKey(plaintext string) (*APIKey, *User, error) {
+	stmt := `SELECT api_keys.id, api_keys.name, api_keys.prefix, api_keys.permissions, api_keys.created_at, api_keys.expiry, api_keys.last_used_at,
+    users.id, users.created_at, users.name, users.email, users.pending_email, users.password_hash, users.activated, users.deletion_scheduled_at, users.deactivated_at, users.version
+  FROM api_keys
+  INNER JOIN users ON users.id = api_keys.user_id
+  WHERE api_keys.hash = $1
+  AND (api_keys.expiry IS NULL OR api_keys.expiry > NOW())`
+
+	hash := sha256.Sum256([]byte(plaintext))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var key APIKey
+	var user User
+
+	err := m.DB.QueryRowContext(ctx, stmt, hash[:]).Scan(
+		&key.ID,
+		&key.Name,
+		&key.Prefix,
+		pq.Array((*[]string)(&key.Permissions)),
+		&key.CreatedAt,
+		&key.Expiry,
+		&key.LastUsedAt,
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.PendingEmail,
+		&user.Password.hash,
+		&user.Activated,
+		&user.DeletionScheduledAt,
+		&user.DeactivatedAt,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+
+	key.UserID = user.ID
+
+	return &key, &user, nil
+}
+
+// revokes one of the user's keys
+// returns ErrRecordNotFound if the key doesn't exist or belongs to someone else
+func (m *APIKeyModel) DeleteForUser(id, userID int64) error {
+	stmt := `DELETE FROM api_keys
+  WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, stmt, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (m *APIKeyModel) DeleteAllForUser(userID int64) error {
+	stmt := `DELETE FROM api_keys
+  WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, stmt, userID)
+	return err
+}
+
+// writes last used times for many keys in a single statement
+func (m *APIKeyModel) UpdateLastUsed(lastUsed map[int64]time.Time) error {
+	if len(lastUsed) == 0 {
+		return nil
+	}
+
+	stmt := `UPDATE api_keys
+  SET last_used_at = to_timestamp(u.used_at)
+  FROM unnest($1::bigint[], $2::bigint[]) AS u(id, used_at)
+  WHERE api_keys.id = u.id
+  AND (api_keys.last_used_at IS NULL OR api_keys.last_used_at < to_timestamp(u.used_at))`
+
+	ids := make([]int64, 0, len(lastUsed))
+	usedAt := make([]int64, 0, len(lastUsed))
+
+	for id, t := range lastUsed {
+		ids = append(ids, id)
+		usedAt = append(usedAt, t.Unix())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, stmt, pq.Array(ids), pq.Array(usedAt))
+	return err
+}
+
+// a key only grants what it was created with and what its user still has
+func (k *APIKey) Allows(code string, userPermissions Permissions) bool {
+	return slices.Contains(k.Permissions, code) && userPermissions.Include(code)
+}
@@ -0,0 +1,112 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Collaborator is a user who was given edit rights on a single movie by its
+// creator, on top of the movies they created themselves
+type Collaborator struct {
+	UserID    int64     `json:"user_id"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type CollaboratorModel struct {
+	DB *sql.DB
+}
+
+// returns the collaborators on a movie, oldest first
+func (m *CollaboratorModel) GetAllForMovie(movieID int64) ([]*Collaborator, error) {
+	stmt := `SELECT users.id, users.name, users.email, movies_collaborators.created_at
+  FROM movies_collaborators
+  INNER JOIN users ON users.id = movies_collaborators.user_id
+  WHERE movies_collaborators.movie_id = $1
+  ORDER BY movies_collaborators.created_at, users.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, stmt, movieID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	collaborators := []*Collaborator{}
+
+	for rows.Next() {
+		var c Collaborator
+
+		err := rows.Scan(&c.UserID, &c.Name, &c.Email, &c.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		collaborators = append(collaborators, &c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return collaborators, nil
+}
+
+// whether the user was added as a collaborator on the movie
+func (m *CollaboratorModel) Exists(movieID, userID int64) (bool, error) {
+	stmt := `SELECT EXISTS (
+    SELECT 1 FROM movies_collaborators WHERE movie_id = $1 AND user_id = $2
+  )`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var exists bool
+
+	err := m.DB.QueryRowContext(ctx, stmt, movieID, userID).Scan(&exists)
+
+	return exists, err
+}
+
+// adding someone who is already a collaborator is a no-op
+func (m *CollaboratorModel) Insert(movieID, userID int64) error {
+	stmt := `INSERT INTO movies_collaborators (movie_id, user_id)
+  VALUES ($1, $2)
+  ON CONFLICT DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, stmt, movieID, userID)
+
+	return err
+}
+
+// returns ErrRecordNotFound if the user isn't a collaborator on the movie
+func (m *CollaboratorModel) Delete(movieID, userID int64) error {
+	stmt := `DELETE FROM movies_collaborators
+  WHERE movie_id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, stmt, movieID, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
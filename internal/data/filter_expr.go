@@ -0,0 +1,435 @@
+package data
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// filter expression grammar used by ?filter= on list endpoints
+// example: year>=1990 and runtime<120 and genres has "comedy"
+//
+//	expr       = or
+//	or         = and { "or" and }
+//	and        = unary { "and" unary }
+//	unary      = "not" unary | primary
+//	primary    = "(" expr ")" | comparison
+//	comparison = field operator value
+//	value      = number | "quoted string"
+//
+// the expression is parsed into an AST, checked against a whitelist of fields
+// and operators, then compiled into parameterised SQL so user input never
+// ends up in the query string itself
+
+const (
+	filterMaxLength = 1000
+	filterMaxTerms  = 20
+)
+
+// type of value a filterable field holds, decides which operators are allowed
+type FilterFieldKind int
+
+const (
+	FilterInt FilterFieldKind = iota
+	FilterText
+	FilterTextArray
+)
+
+// FilterField describes a single field that can be used in a filter expression
+// Column is trusted SQL and must never come from user input
+type FilterField struct {
+	Column string
+	Kind   FilterFieldKind
+}
+
+// operators allowed for each kind of field
+var filterOperators = map[FilterFieldKind][]string{
+	FilterInt:       {"=", "!=", "<", "<=", ">", ">="},
+	FilterText:      {"=", "!=", "~"},
+	FilterTextArray: {"has"},
+}
+
+// FilterError holds a message along with the 1-based character position in
+// the expression where the problem was found
+type FilterError struct {
+	Pos int
+	Msg string
+}
+
+func (e *FilterError) Error() string {
+	return fmt.Sprintf("%s (at position %d)", e.Msg, e.Pos)
+}
+
+// filterNode is a node of the parsed AST
+// sql() writes the node as SQL and appends the values for its placeholders to args
+type filterNode interface {
+	sql(args *[]any) string
+}
+
+type filterLogical struct {
+	op          string // AND / OR
+	left, right filterNode
+}
+
+func (n *filterLogical) sql(args *[]any) string {
+	return fmt.Sprintf("(%s %s %s)", n.left.sql(args), n.op, n.right.sql(args))
+}
+
+type filterNot struct {
+	expr filterNode
+}
+
+func (n *filterNot) sql(args *[]any) string {
+	return fmt.Sprintf("(NOT %s)", n.expr.sql(args))
+}
+
+type filterComparison struct {
+	field FilterField
+	op    string
+	value any
+}
+
+func (n *filterComparison) sql(args *[]any) string {
+	value := n.value
+
+	// ~ is a substring match, % and _ in the value are matched literally
+	if n.op == "~" {
+		value = likeEscaper.Replace(value.(string))
+	}
+
+	*args = append(*args, value)
+	placeholder := fmt.Sprintf("$%d", len(*args))
+
+	switch n.field.Kind {
+	case FilterText:
+		switch n.op {
+		case "~":
+			return fmt.Sprintf(`(lower(%s) LIKE lower('%%' || %s || '%%') ESCAPE '\')`, n.field.Column, placeholder)
+		default:
+			return fmt.Sprintf("(lower(%s) %s lower(%s))", n.field.Column, n.op, placeholder)
+		}
+	case FilterTextArray:
+		return fmt.Sprintf("(%s @> ARRAY[%s::text])", n.field.Column, placeholder)
+	default:
+		// cast so integer columns compare against the full bigint range
+		// rather than postgres inferring their own, smaller, type
+		return fmt.Sprintf("(%s %s %s::bigint)", n.field.Column, n.op, placeholder)
+	}
+}
+
+// FilterExpr is a parsed and validated filter expression
+// a nil *FilterExpr matches every row
+type FilterExpr struct {
+	root filterNode
+}
+
+// SQL compiles the expression into a WHERE clause fragment
+// placeholders are numbered after the values already in args
+func (f *FilterExpr) SQL(args *[]any) string {
+	if f == nil || f.root == nil {
+		return "TRUE"
+	}
+
+	return f.root.sql(args)
+}
+
+// ParseFilterExpr parses input using the given field whitelist
+// an empty input returns a nil expression and no error
+func ParseFilterExpr(input string, fields map[string]FilterField) (*FilterExpr, error) {
+	if strings.TrimSpace(input) == "" {
+		return nil, nil
+	}
+
+	if len(input) > filterMaxLength {
+		return nil, &FilterError{Pos: filterMaxLength + 1, Msg: fmt.Sprintf("must not be more than %d bytes long", filterMaxLength)}
+	}
+
+	tokens, err := lexFilter(input)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &filterParser{tokens: tokens, fields: fields}
+
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	// everything should be consumed by now
+	if tok := p.peek(); tok.kind != filterTokenEOF {
+		return nil, &FilterError{Pos: tok.pos, Msg: fmt.Sprintf("unexpected %q", tok.text)}
+	}
+
+	return &FilterExpr{root: root}, nil
+}
+
+type filterTokenKind int
+
+const (
+	filterTokenEOF filterTokenKind = iota
+	filterTokenIdent
+	filterTokenNumber
+	filterTokenString
+	filterTokenOperator
+	filterTokenLParen
+	filterTokenRParen
+)
+
+type filterToken struct {
+	kind filterTokenKind
+	text string // raw text, or unquoted value for strings
+	pos  int    // 1-based position of first character
+}
+
+// splits the input into tokens, positions are counted in characters
+func lexFilter(input string) ([]filterToken, error) {
+	var tokens []filterToken
+
+	runes := []rune(input)
+	i := 0
+
+	for i < len(runes) {
+		r := runes[i]
+		pos := i + 1
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+
+		case r == '(':
+			tokens = append(tokens, filterToken{kind: filterTokenLParen, text: "(", pos: pos})
+			i++
+
+		case r == ')':
+			tokens = append(tokens, filterToken{kind: filterTokenRParen, text: ")", pos: pos})
+			i++
+
+		case r == '"':
+			// quoted string, backslash escapes the next character
+			var sb strings.Builder
+			i++
+			closed := false
+
+			for i < len(runes) {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					sb.WriteRune(runes[i+1])
+					i += 2
+					continue
+				}
+				if runes[i] == '"' {
+					closed = true
+					i++
+					break
+				}
+				sb.WriteRune(runes[i])
+				i++
+			}
+
+			if !closed {
+				return nil, &FilterError{Pos: pos, Msg: "unterminated string"}
+			}
+
+			tokens = append(tokens, filterToken{kind: filterTokenString, text: sb.String(), pos: pos})
+
+		case strings.ContainsRune("=!<>~", r):
+			op := string(r)
+			if i+1 < len(runes) && runes[i+1] == '=' && r != '=' && r != '~' {
+				op += "="
+			}
+
+			if op == "!" {
+				return nil, &FilterError{Pos: pos, Msg: `unexpected "!", did you mean "!="`}
+			}
+
+			tokens = append(tokens, filterToken{kind: filterTokenOperator, text: op, pos: pos})
+			i += len(op)
+
+		case isFilterDigit(r) || (r == '-' && i+1 < len(runes) && isFilterDigit(runes[i+1])):
+			start := i
+			i++
+			for i < len(runes) && isFilterDigit(runes[i]) {
+				i++
+			}
+			tokens = append(tokens, filterToken{kind: filterTokenNumber, text: string(runes[start:i]), pos: pos})
+
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || isFilterDigit(runes[i]) || runes[i] == '_') {
+				i++
+			}
+			tokens = append(tokens, filterToken{kind: filterTokenIdent, text: string(runes[start:i]), pos: pos})
+
+		default:
+			return nil, &FilterError{Pos: pos, Msg: fmt.Sprintf("unexpected character %q", r)}
+		}
+	}
+
+	tokens = append(tokens, filterToken{kind: filterTokenEOF, text: "end of input", pos: len(runes) + 1})
+
+	return tokens, nil
+}
+
+// only ASCII digits, other unicode digits can't be parsed as numbers
+func isFilterDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
+
+// recursive descent parser over the lexed tokens
+type filterParser struct {
+	tokens []filterToken
+	i      int
+	terms  int
+	fields map[string]FilterField
+}
+
+func (p *filterParser) peek() filterToken {
+	return p.tokens[p.i]
+}
+
+func (p *filterParser) next() filterToken {
+	tok := p.tokens[p.i]
+	if tok.kind != filterTokenEOF {
+		p.i++
+	}
+	return tok
+}
+
+// keywords are case-insensitive
+func (p *filterParser) isKeyword(word string) bool {
+	tok := p.peek()
+	return tok.kind == filterTokenIdent && strings.EqualFold(tok.text, word)
+}
+
+func (p *filterParser) parseOr() (filterNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isKeyword("or") {
+		p.next()
+
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+
+		left = &filterLogical{op: "OR", left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (filterNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isKeyword("and") {
+		p.next()
+
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		left = &filterLogical{op: "AND", left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (filterNode, error) {
+	if p.isKeyword("not") {
+		p.next()
+
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		return &filterNot{expr: expr}, nil
+	}
+
+	return p.parsePrimary()
+}
+
+func (p *filterParser) parsePrimary() (filterNode, error) {
+	tok := p.peek()
+
+	if tok.kind == filterTokenLParen {
+		p.next()
+
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		if closing := p.next(); closing.kind != filterTokenRParen {
+			return nil, &FilterError{Pos: closing.pos, Msg: fmt.Sprintf("expected \")\" but found %q", closing.text)}
+		}
+
+		return expr, nil
+	}
+
+	return p.parseComparison()
+}
+
+func (p *filterParser) parseComparison() (filterNode, error) {
+	fieldTok := p.next()
+	if fieldTok.kind != filterTokenIdent {
+		return nil, &FilterError{Pos: fieldTok.pos, Msg: fmt.Sprintf("expected a field name but found %q", fieldTok.text)}
+	}
+
+	field, ok := p.fields[strings.ToLower(fieldTok.text)]
+	if !ok {
+		return nil, &FilterError{Pos: fieldTok.pos, Msg: fmt.Sprintf("unknown field %q", fieldTok.text)}
+	}
+
+	p.terms++
+	if p.terms > filterMaxTerms {
+		return nil, &FilterError{Pos: fieldTok.pos, Msg: fmt.Sprintf("must not contain more than %d comparisons", filterMaxTerms)}
+	}
+
+	// "has" is lexed as an identifier, everything else as an operator
+	opTok := p.next()
+	op := opTok.text
+	if opTok.kind == filterTokenIdent {
+		op = strings.ToLower(op)
+	} else if opTok.kind != filterTokenOperator {
+		return nil, &FilterError{Pos: opTok.pos, Msg: fmt.Sprintf("expected an operator but found %q", opTok.text)}
+	}
+
+	allowed := filterOperators[field.Kind]
+	if !slices.Contains(allowed, op) {
+		return nil, &FilterError{Pos: opTok.pos, Msg: fmt.Sprintf("operator %q is not supported for field %q (use one of: %s)", opTok.text, fieldTok.text, strings.Join(allowed, " "))}
+	}
+
+	valTok := p.next()
+
+	switch field.Kind {
+	case FilterInt:
+		if valTok.kind != filterTokenNumber {
+			return nil, &FilterError{Pos: valTok.pos, Msg: fmt.Sprintf("field %q expects a number", fieldTok.text)}
+		}
+
+		// id is a bigint, so numbers can be as large as one
+		n, err := strconv.ParseInt(valTok.text, 10, 64)
+		if err != nil {
+			return nil, &FilterError{Pos: valTok.pos, Msg: fmt.Sprintf("number %s is out of range", valTok.text)}
+		}
+
+		return &filterComparison{field: field, op: op, value: n}, nil
+
+	default:
+		if valTok.kind != filterTokenString {
+			return nil, &FilterError{Pos: valTok.pos, Msg: fmt.Sprintf("field %q expects a quoted string", fieldTok.text)}
+		}
+
+		return &filterComparison{field: field, op: op, value: valTok.text}, nil
+	}
+}
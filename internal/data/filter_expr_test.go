@@ -0,0 +1,158 @@
+package data
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseFilterExpr(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantSQL string
+		args    []any
+	}{
+		{
+			name:    "empty",
+			input:   "  ",
+			wantSQL: "TRUE",
+		},
+		{
+			name:    "and binds tighter than or",
+			input:   "year>=1990 or year<1950 and runtime<120",
+			wantSQL: "((year >= $1::bigint) OR ((year < $2::bigint) AND (runtime < $3::bigint)))",
+			args:    []any{int64(1990), int64(1950), int64(120)},
+		},
+		{
+			name:    "parentheses override precedence",
+			input:   "(year>=1990 or year<1950) and runtime<120",
+			wantSQL: "(((year >= $1::bigint) OR (year < $2::bigint)) AND (runtime < $3::bigint))",
+			args:    []any{int64(1990), int64(1950), int64(120)},
+		},
+		{
+			name:    "not binds tighter than and",
+			input:   "not year=2000 and runtime>90",
+			wantSQL: "((NOT (year = $1::bigint)) AND (runtime > $2::bigint))",
+			args:    []any{int64(2000), int64(90)},
+		},
+		{
+			name:    "keywords and fields are case-insensitive",
+			input:   `NOT Year != 2000 AND GENRES HAS "drama"`,
+			wantSQL: "((NOT (year != $1::bigint)) AND (genres @> ARRAY[$2::text]))",
+			args:    []any{int64(2000), "drama"},
+		},
+		{
+			name:    "quoted string with escaped quote",
+			input:   `title = "say \"hi\""`,
+			wantSQL: "(lower(title) = lower($1))",
+			args:    []any{`say "hi"`},
+		},
+		{
+			name:    "substring match escapes like wildcards",
+			input:   `title ~ "50%_off\\"`,
+			wantSQL: `(lower(title) LIKE lower('%' || $1 || '%') ESCAPE '\')`,
+			args:    []any{`50\%\_off\\`},
+		},
+		{
+			name:    "column names come from the field list",
+			input:   `language = "en"`,
+			wantSQL: "(lower(original_language) = lower($1))",
+			args:    []any{"en"},
+		},
+		{
+			name:    "ids beyond int32",
+			input:   "id = 9007199254740993",
+			wantSQL: "(id = $1::bigint)",
+			args:    []any{int64(9007199254740993)},
+		},
+		{
+			name:    "negative numbers",
+			input:   "year > -1",
+			wantSQL: "(year > $1::bigint)",
+			args:    []any{int64(-1)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := ParseFilterExpr(tt.input, MovieFilterFields)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var args []any
+
+			if got := expr.SQL(&args); got != tt.wantSQL {
+				t.Errorf("SQL = %s, want %s", got, tt.wantSQL)
+			}
+
+			if !reflect.DeepEqual(args, tt.args) {
+				t.Errorf("args = %#v, want %#v", args, tt.args)
+			}
+		})
+	}
+}
+
+func TestParseFilterExprPlaceholdersFollowArgs(t *testing.T) {
+	expr, err := ParseFilterExpr("year = 2000", MovieFilterFields)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args := []any{"already", "there"}
+
+	if got, want := expr.SQL(&args), "(year = $3::bigint)"; got != want {
+		t.Errorf("SQL = %s, want %s", got, want)
+	}
+}
+
+func TestParseFilterExprErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantPos int
+		wantMsg string
+	}{
+		{"unknown field", "foo = 1", 1, `unknown field "foo"`},
+		{"missing value", "year >= ", 9, `field "year" expects a number`},
+		{"missing operator", "year 1990", 6, "expected an operator"},
+		{"operator not allowed for text", `title > "x"`, 7, `operator ">" is not supported for field "title"`},
+		{"operator not allowed for arrays", `genres = "drama"`, 8, `operator "=" is not supported for field "genres"`},
+		{"string for number field", `year = "1990"`, 8, `field "year" expects a number`},
+		{"number for text field", "title = 1990", 9, `field "title" expects a quoted string`},
+		{"unclosed parenthesis", "(year = 1", 10, `expected ")"`},
+		{"unterminated string", `title = "abc`, 9, "unterminated string"},
+		{"bang without equals", "year ! 1", 6, `did you mean "!="`},
+		{"missing and", "year = 1 year = 2", 10, `unexpected "year"`},
+		{"dangling and", "year = 1 and", 13, "expected a field name"},
+		{"non-ascii digits", "year = ١٢", 8, "unexpected character"},
+		{"number out of range", "id = 99999999999999999999", 6, "out of range"},
+		{"unexpected character", "year = 1 @", 10, "unexpected character"},
+		{"too many comparisons", strings.Repeat("year = 1 and ", filterMaxTerms) + "year = 1", 13*filterMaxTerms + 1, "must not contain more than"},
+		{"too long", "title = \"" + strings.Repeat("a", filterMaxLength) + "\"", filterMaxLength + 1, "must not be more than"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := ParseFilterExpr(tt.input, MovieFilterFields)
+			if err == nil {
+				t.Fatalf("expected an error, got %v", expr)
+			}
+
+			var filterErr *FilterError
+			if !errors.As(err, &filterErr) {
+				t.Fatalf("error is %T, want *FilterError", err)
+			}
+
+			if filterErr.Pos != tt.wantPos {
+				t.Errorf("position = %d, want %d (%v)", filterErr.Pos, tt.wantPos, err)
+			}
+
+			if !strings.Contains(filterErr.Msg, tt.wantMsg) {
+				t.Errorf("message = %q, want it to contain %q", filterErr.Msg, tt.wantMsg)
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/V4N1LLA-1CE/movie-db-api/internal/validator"
+	"github.com/lib/pq"
+)
+
+// Invitation lets someone register when registration is invite-only, the
+// permissions are granted to them instead of the default role
+type Invitation struct {
+	ID          int64       `json:"id"`
+	CreatedAt   time.Time   `json:"created_at"`
+	Email       string      `json:"email"`
+	Permissions Permissions `json:"permissions"`
+	InvitedBy   *int64      `json:"invited_by"` // nil once the admin who sent it is deleted
+	Expiry      time.Time   `json:"expiry"`
+	Plaintext   string      `json:"-"` // only set when the invitation is created, it's emailed to the invitee
+	Hash        []byte      `json:"-"`
+	KeyID       string      `json:"-"` // the pepper the hash was made with, empty for legacy sha256 invitations
+}
+
+// known is every permission that exists, invitations can't grant anything else
+func ValidateInvitation(v *validator.Validator, invitation *Invitation, known Permissions) {
+	ValidateEmail(v, invitation.Email)
+
+	v.Check(invitation.Permissions != nil, "permissions", "must be provided")
+	v.Check(validator.Unique(invitation.Permissions), "permissions", "must not contain duplicate values")
+
+	for _, p := range invitation.Permissions {
+		if !known.Include(p) {
+			v.AddError("permissions", strconv.Quote(p)+" is not a known permission")
+			break
+		}
+	}
+}
+
+type InvitationModel struct {
+	DB *sql.DB
+}
+
+// creates an invitation, a newer one for the same email replaces the old one
+// so only the latest link works
+func (m *InvitationModel) New(email string, permissions Permissions, invitedBy int64, ttl time.Duration) (*Invitation, error) {
+	plaintext, keyID, err := newTokenPlaintext()
+	if err != nil {
+		return nil, err
+	}
+
+	invitation := &Invitation{
+		Email:       email,
+		Permissions: permissions,
+		InvitedBy:   &invitedBy,
+		Expiry:      time.Now().Add(ttl),
+		Plaintext:   plaintext,
+		Hash:        tokenHash(plaintext),
+		KeyID:       keyID,
+	}
+
+	stmt := `INSERT INTO invitations (email, permissions, invited_by, hash, key_id, expiry)
+  VALUES ($1, $2, $3, $4, $5, $6)
+  ON CONFLICT (email) DO UPDATE
+  SET created_at = NOW(), permissions = EXCLUDED.permissions, invited_by = EXCLUDED.invited_by, hash = EXCLUDED.hash, key_id = EXCLUDED.key_id, expiry = EXCLUDED.expiry
+  RETURNING id, created_at`
+
+	args := []any{invitation.Email, pq.Array([]string(invitation.Permissions)), invitation.InvitedBy, invitation.Hash, invitation.KeyID, invitation.Expiry}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, stmt, args...).Scan(&invitation.ID, &invitation.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return invitation, nil
+}
+
+// returns the invitation for a token that hasn't expired yet
+func (m *InvitationModel) GetForToken(tokenPlaintext string) (*Invitation, error) {
+	stmt := `SELECT id, created_at, email, permissions, invited_by, expiry
+  FROM invitations
+  WHERE hash = $1 AND expiry > $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var invitation Invitation
+
+	err := m.DB.QueryRowContext(ctx, stmt, tokenHash(tokenPlaintext), time.Now()).Scan(
+		&invitation.ID,
+		&invitation.CreatedAt,
+		&invitation.Email,
+		pq.Array((*[]string)(&invitation.Permissions)),
+		&invitation.InvitedBy,
+		&invitation.Expiry,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &invitation, nil
+}
+
+// returns the invitations that haven't been used or expired, newest first
+func (m *InvitationModel) GetAllPending() ([]*Invitation, error) {
+	stmt := `SELECT id, created_at, email, permissions, invited_by, expiry
+  FROM invitations
+  WHERE expiry > $1
+  ORDER BY created_at DESC, id DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, stmt, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invitations := []*Invitation{}
+
+	for rows.Next() {
+		var invitation Invitation
+
+		err := rows.Scan(
+			&invitation.ID,
+			&invitation.CreatedAt,
+			&invitation.Email,
+			pq.Array((*[]string)(&invitation.Permissions)),
+			&invitation.InvitedBy,
+			&invitation.Expiry,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		invitations = append(invitations, &invitation)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return invitations, nil
+}
+
+// registers user with the permissions from the invitation for tokenPlaintext.
+// The invitation is claimed, the user inserted and the permissions granted in
+// one transaction so a revoked invitation can't grant anything and a failure
+// leaves the invitation usable. Returns ErrRecordNotFound if the invitation
+// was used, revoked or expired or was sent to a different email and
+// ErrDuplicateEmail if the email is taken
+func (m *InvitationModel) Accept(tokenPlaintext string, user *User) (*Invitation, error) {
+	claimStmt := `DELETE FROM invitations
+  WHERE hash = $1 AND email = $2 AND expiry > $3
+  RETURNING id, created_at, email, permissions, invited_by, expiry`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var invitation Invitation
+
+	err = tx.QueryRowContext(ctx, claimStmt, tokenHash(tokenPlaintext), user.Email, time.Now()).Scan(
+		&invitation.ID,
+		&invitation.CreatedAt,
+		&invitation.Email,
+		pq.Array((*[]string)(&invitation.Permissions)),
+		&invitation.InvitedBy,
+		&invitation.Expiry,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	err = insertUser(ctx, tx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(invitation.Permissions) > 0 {
+		err = addPermissionsForUser(ctx, tx, user.ID, invitation.Permissions)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return &invitation, nil
+}
+
+// removes an invitation when it's revoked
+// returns ErrRecordNotFound if it doesn't exist
+func (m *InvitationModel) Delete(id int64) error {
+	stmt := `DELETE FROM invitations
+  WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, stmt, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
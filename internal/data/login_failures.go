@@ -0,0 +1,119 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// failures older than this are forgotten, the count starts again from 1
+const loginFailureWindow = 24 * time.Hour
+
+// LoginFailure is the failed login count for an email address
+type LoginFailure struct {
+	Email        string
+	Failures     int
+	LastFailedAt time.Time
+	LockedUntil  *time.Time
+}
+
+// whether logins for the email are refused outright
+func (f *LoginFailure) Locked(now time.Time) bool {
+	return f != nil && f.LockedUntil != nil && f.LockedUntil.After(now)
+}
+
+type LoginFailureModel struct {
+	DB *sql.DB
+}
+
+// returns the failures for an email, nil with no error if there aren't any
+func (m *LoginFailureModel) Get(email string) (*LoginFailure, error) {
+	stmt := `SELECT email, failures, last_failed_at, locked_until
+  FROM login_failures
+  WHERE email = $1 AND (last_failed_at > $2 OR locked_until > NOW())`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var f LoginFailure
+
+	err := m.DB.QueryRowContext(ctx, stmt, email, time.Now().Add(-loginFailureWindow)).Scan(&f.Email, &f.Failures, &f.LastFailedAt, &f.LockedUntil)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil
+		default:
+			return nil, err
+		}
+	}
+
+	return &f, nil
+}
+
+// counts a failed login for email. Once there have been maxFailures the email
+// is locked for lockout and the count starts over, the returned failure is
+// Locked when that happens
+func (m *LoginFailureModel) Record(email string, maxFailures int, lockout time.Duration) (*LoginFailure, error) {
+	stmt := `INSERT INTO login_failures (email, failures, last_failed_at)
+  VALUES ($1, 1, NOW())
+  ON CONFLICT (email) DO UPDATE
+  SET failures = CASE WHEN login_failures.last_failed_at > $2 THEN login_failures.failures + 1 ELSE 1 END,
+    last_failed_at = NOW()
+  RETURNING email, failures, last_failed_at, locked_until`
+
+	lockStmt := `UPDATE login_failures
+  SET failures = 0, locked_until = $2
+  WHERE email = $1
+  RETURNING failures, locked_until`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var f LoginFailure
+
+	err := m.DB.QueryRowContext(ctx, stmt, email, time.Now().Add(-loginFailureWindow)).Scan(&f.Email, &f.Failures, &f.LastFailedAt, &f.LockedUntil)
+	if err != nil {
+		return nil, err
+	}
+
+	if f.Failures < maxFailures {
+		return &f, nil
+	}
+
+	err = m.DB.QueryRowContext(ctx, lockStmt, email, time.Now().Add(lockout)).Scan(&f.Failures, &f.LockedUntil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &f, nil
+}
+
+// clears failures and any lockout for an email, i.e. after a successful login
+func (m *LoginFailureModel) Reset(email string) error {
+	stmt := `DELETE FROM login_failures
+  WHERE email = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, stmt, email)
+	return err
+}
+
+// removes failures that have been forgotten and lockouts that have ended
+func (m *LoginFailureModel) DeleteStale() (int64, error) {
+	stmt := `DELETE FROM login_failures
+  WHERE last_failed_at <= $1
+  AND (locked_until IS NULL OR locked_until <= NOW())`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, stmt, time.Now().Add(-loginFailureWindow))
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -12,18 +12,34 @@ var (
 
 // models struct wraps all models using a single container
 type Models struct {
-	Movies      MovieModel
-	Permissions PermissionModel
-	Users       UserModel
-	Tokens      TokenModel
+	Movies         MovieModel
+	Collaborators  CollaboratorModel
+	Permissions    PermissionModel
+	Roles          RoleModel
+	Users          UserModel
+	Tokens         TokenModel
+	Denylist       SessionDenylistModel
+	APIKeys        APIKeyModel
+	TOTP           TOTPModel
+	LoginFailures  LoginFailureModel
+	SecurityEvents SecurityEventModel
+	Invitations    InvitationModel
 	// TODO: Add more models here when needed
 }
 
 func NewModels(db *sql.DB) Models {
 	return Models{
-		Movies:      MovieModel{DB: db},
-		Permissions: PermissionModel{DB: db},
-		Users:       UserModel{DB: db},
-		Tokens:      TokenModel{DB: db},
+		Movies:         MovieModel{DB: db},
+		Collaborators:  CollaboratorModel{DB: db},
+		Permissions:    PermissionModel{DB: db},
+		Roles:          RoleModel{DB: db},
+		Users:          UserModel{DB: db},
+		Tokens:         TokenModel{DB: db},
+		Denylist:       SessionDenylistModel{DB: db},
+		APIKeys:        APIKeyModel{DB: db},
+		TOTP:           TOTPModel{DB: db},
+		LoginFailures:  LoginFailureModel{DB: db},
+		SecurityEvents: SecurityEventModel{DB: db},
+		Invitations:    InvitationModel{DB: db},
 	}
 }
@@ -0,0 +1,232 @@
+package data
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/V4N1LLA-1CE/movie-db-api/internal/validator"
+	"github.com/lib/pq"
+)
+
+// page of movie changes since a change token
+// created/updated use the same Movie shape as the rest of the api
+type MovieChanges struct {
+	Created   []*Movie `json:"created"`
+	Updated   []*Movie `json:"updated"`
+	Deleted   []int64  `json:"deleted"`
+	NextToken string   `json:"next_token"`
+	HasMore   bool     `json:"has_more"`
+}
+
+// ChangeToken is the position of the last change a client has seen
+//
+// changes are ordered by the id of the transaction that wrote them, then by
+// movies_change_seq. Reads stop before the oldest transaction still running,
+// so a change can't commit behind a token a client already has. Tokens look
+// like "<xid>-<seq>", plain sequence values from before xids were tracked are
+// still accepted since every change from then has xid 1
+type ChangeToken struct {
+	XID uint64
+	Seq int64
+}
+
+func (t ChangeToken) String() string {
+	return strconv.FormatUint(t.XID, 10) + "-" + strconv.FormatInt(t.Seq, 10)
+}
+
+// an empty token means the client has nothing yet and wants a full sync
+func ParseChangeToken(v *validator.Validator, token string) ChangeToken {
+	if token == "" {
+		return ChangeToken{}
+	}
+
+	xid, seq, found := strings.Cut(token, "-")
+	if !found {
+		xid, seq = "1", token
+	}
+
+	var t ChangeToken
+	var err1, err2 error
+
+	t.XID, err1 = strconv.ParseUint(xid, 10, 64)
+	t.Seq, err2 = strconv.ParseInt(seq, 10, 64)
+	if err1 != nil || err2 != nil || t.Seq < 0 {
+		v.AddError("since", "must be a valid change token")
+		return ChangeToken{}
+	}
+
+	return t
+}
+
+// reports whether the change at xid and seq comes after the token
+func (t ChangeToken) before(xid uint64, seq int64) bool {
+	return xid > t.XID || (xid == t.XID && seq > t.Seq)
+}
+
+// GetChanges returns up to limit movie changes after the since token, oldest first
+// a long running transaction anywhere in the db holds changes back until it
+// finishes, they're never skipped
+func (m *MovieModel) GetChanges(since ChangeToken, limit int) (*MovieChanges, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// every transaction older than this has finished, and anything that
+	// commits from now on will have a higher xid. Both tables are read up to
+	// the same bound so merging them can't pass over a change in either
+	var horizon string
+
+	err := m.DB.QueryRowContext(ctx, `SELECT pg_snapshot_xmin(pg_current_snapshot())::text`).Scan(&horizon)
+	if err != nil {
+		return nil, err
+	}
+
+	// fetch one extra row from each table to know if there are more pages
+	moviesStmt := `SELECT change_xid::text, change_seq, created_xid::text, created_seq, id, created_at, updated_at, title, year, runtime, genres, plot, tagline, original_language, countries, homepage, created_by, version
+  FROM movies
+  WHERE (change_xid, change_seq) > ($1::xid8, $2)
+  AND change_xid < $3::xid8
+  ORDER BY change_xid ASC, change_seq ASC
+  LIMIT $4`
+
+	tombstonesStmt := `SELECT change_xid::text, change_seq, movie_id
+  FROM movie_tombstones
+  WHERE (change_xid, change_seq) > ($1::xid8, $2)
+  AND change_xid < $3::xid8
+  ORDER BY change_xid ASC, change_seq ASC
+  LIMIT $4`
+
+	args := []any{strconv.FormatUint(since.XID, 10), since.Seq, horizon, limit + 1}
+
+	type change struct {
+		token   ChangeToken
+		created bool
+		movie   *Movie
+		deleted int64
+	}
+
+	var movieChanges []change
+
+	rows, err := m.DB.QueryContext(ctx, moviesStmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c change
+		var changeXID, createdXID string
+		var createdSeq int64
+		var movie Movie
+
+		err := rows.Scan(
+			&changeXID,
+			&c.token.Seq,
+			&createdXID,
+			&createdSeq,
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.UpdatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Plot,
+			&movie.Tagline,
+			&movie.Language,
+			pq.Array(&movie.Countries),
+			&movie.Homepage,
+			&movie.CreatedBy,
+			&movie.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		c.token.XID, err = strconv.ParseUint(changeXID, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		created, err := strconv.ParseUint(createdXID, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		// anything created after the token is new to the client
+		c.created = since.before(created, createdSeq)
+		c.movie = &movie
+		movieChanges = append(movieChanges, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var tombstoneChanges []change
+
+	rows, err = m.DB.QueryContext(ctx, tombstonesStmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c change
+		var changeXID string
+
+		err := rows.Scan(&changeXID, &c.token.Seq, &c.deleted)
+		if err != nil {
+			return nil, err
+		}
+
+		c.token.XID, err = strconv.ParseUint(changeXID, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		tombstoneChanges = append(tombstoneChanges, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	changes := &MovieChanges{
+		Created:   []*Movie{},
+		Updated:   []*Movie{},
+		Deleted:   []int64{},
+		NextToken: since.String(),
+	}
+
+	// merge both lists in change order and stop at limit
+	i, j, n := 0, 0, 0
+	for n < limit && (i < len(movieChanges) || j < len(tombstoneChanges)) {
+		var c change
+
+		if j >= len(tombstoneChanges) || (i < len(movieChanges) && movieChanges[i].token.before(tombstoneChanges[j].token.XID, tombstoneChanges[j].token.Seq)) {
+			c = movieChanges[i]
+			i++
+		} else {
+			c = tombstoneChanges[j]
+			j++
+		}
+
+		switch {
+		case c.movie == nil:
+			changes.Deleted = append(changes.Deleted, c.deleted)
+		case c.created:
+			changes.Created = append(changes.Created, c.movie)
+		default:
+			changes.Updated = append(changes.Updated, c.movie)
+		}
+
+		changes.NextToken = c.token.String()
+		n++
+	}
+
+	changes.HasMore = i < len(movieChanges) || j < len(tombstoneChanges)
+
+	return changes, nil
+}
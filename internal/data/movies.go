@@ -5,7 +5,9 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/V4N1LLA-1CE/movie-db-api/internal/validator"
 	"github.com/google/uuid"
@@ -16,11 +18,32 @@ import (
 type Movie struct {
 	ID        int64     `json:"id"`
 	CreatedAt time.Time `json:"-"` // hide (not needed in json response)
+	UpdatedAt time.Time `json:"-"` // hide, maintained for delta sync
 	Title     string    `json:"title"`
 	Year      int32     `json:"year,omitempty"`    // don't show in response if empty
 	Runtime   int32     `json:"runtime,omitempty"` // don't show in response if empty
 	Genres    []string  `json:"genres,omitempty"`  // don't show in response if empty
-	Version   uuid.UUID `json:"version"`           // needed for locking to prevent race conditions
+	Plot      string    `json:"plot,omitempty"`
+	Tagline   string    `json:"tagline,omitempty"`
+	Language  string    `json:"original_language,omitempty"` // ISO 639-1
+	Countries []string  `json:"countries,omitempty"`         // ISO 3166-1 alpha-2
+	Homepage  string    `json:"homepage,omitempty"`
+	CreatedBy *int64    `json:"created_by,omitempty"` // nil for movies added before ownership was tracked
+	Version   uuid.UUID `json:"version"`              // needed for locking to prevent race conditions
+}
+
+// fields that can be used in the ?filter= expression on the movie list
+var MovieFilterFields = map[string]FilterField{
+	"id":      {Column: "id", Kind: FilterInt},
+	"title":   {Column: "title", Kind: FilterText},
+	"year":    {Column: "year", Kind: FilterInt},
+	"runtime": {Column: "runtime", Kind: FilterInt},
+	"genres":  {Column: "genres", Kind: FilterTextArray},
+
+	"plot":      {Column: "plot", Kind: FilterText},
+	"tagline":   {Column: "tagline", Kind: FilterText},
+	"language":  {Column: "original_language", Kind: FilterText},
+	"countries": {Column: "countries", Kind: FilterTextArray},
 }
 
 func ValidateMovie(v *validator.Validator, movie *Movie) {
@@ -38,6 +61,25 @@ func ValidateMovie(v *validator.Validator, movie *Movie) {
 	v.Check(len(movie.Genres) >= 1, "genres", "must contain at least 1 genre")
 	v.Check(len(movie.Genres) <= 5, "genres", "must not contain more than 5 genres")
 	v.Check(validator.Unique(movie.Genres), "genres", "must not contain duplicate values")
+
+	// descriptive fields below are optional
+	v.Check(len(movie.Plot) <= 10_000, "plot", "must not be more than 10000 bytes long")
+	v.Check(len(movie.Tagline) <= 500, "tagline", "must not be more than 500 bytes long")
+
+	if movie.Language != "" {
+		v.Check(validator.LanguageCode(movie.Language), "original_language", "must be a valid lowercase ISO 639-1 code")
+	}
+
+	v.Check(len(movie.Countries) <= 10, "countries", "must not contain more than 10 countries")
+	v.Check(validator.Unique(movie.Countries), "countries", "must not contain duplicate values")
+	for _, country := range movie.Countries {
+		v.Check(validator.CountryCode(country), "countries", "must only contain valid uppercase ISO 3166-1 alpha-2 codes")
+	}
+
+	if movie.Homepage != "" {
+		v.Check(len(movie.Homepage) <= 2000, "homepage", "must not be more than 2000 bytes long")
+		v.Check(validator.URL(movie.Homepage), "homepage", "must be a valid http or https URL")
+	}
 }
 
 // movie model wraps sql.db connection pool
@@ -47,22 +89,31 @@ type MovieModel struct {
 
 // CRUD Methods below for movies
 func (m *MovieModel) Insert(movie *Movie) error {
-	stmt := `INSERT INTO movies (title, year, runtime, genres, version)
-  VALUES ($1, $2, $3, $4, uuid_generate_v4())
-  RETURNING id, created_at, version`
+	// created_seq and change_seq take the same value from the change sequence,
+	// created_xid and change_xid default to the id of this transaction
+	stmt := `WITH seq AS (SELECT nextval('movies_change_seq') AS n)
+  INSERT INTO movies (title, year, runtime, genres, plot, tagline, original_language, countries, homepage, created_by, version, created_seq, change_seq)
+  SELECT $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, uuid_generate_v4(), seq.n, seq.n FROM seq
+  RETURNING id, created_at, updated_at, version`
 
 	args := []any{
 		movie.Title,
 		movie.Year,
 		movie.Runtime,
 		movie.Genres,
+		movie.Plot,
+		movie.Tagline,
+		movie.Language,
+		pq.Array(movie.Countries),
+		movie.Homepage,
+		movie.CreatedBy,
 	}
 
 	// context with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	return m.DB.QueryRowContext(ctx, stmt, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	return m.DB.QueryRowContext(ctx, stmt, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.UpdatedAt, &movie.Version)
 }
 
 func (m *MovieModel) Get(id int64) (*Movie, error) {
@@ -70,7 +121,7 @@ func (m *MovieModel) Get(id int64) (*Movie, error) {
 		return nil, ErrRecordNotFound
 	}
 
-	stmt := `SELECT id, created_at, title, year, runtime, genres, version
+	stmt := `SELECT id, created_at, updated_at, title, year, runtime, genres, plot, tagline, original_language, countries, homepage, created_by, version
   FROM movies
   WHERE id = $1`
 
@@ -88,10 +139,17 @@ func (m *MovieModel) Get(id int64) (*Movie, error) {
 	err := m.DB.QueryRowContext(ctx, stmt, id).Scan(
 		&movie.ID,
 		&movie.CreatedAt,
+		&movie.UpdatedAt,
 		&movie.Title,
 		&movie.Year,
 		&movie.Runtime,
 		pq.Array(&movie.Genres), // can't find pgx equivalent, so now i'm using both pq and pgx, f*ck
+		&movie.Plot,
+		&movie.Tagline,
+		&movie.Language,
+		pq.Array(&movie.Countries),
+		&movie.Homepage,
+		&movie.CreatedBy,
 		&movie.Version,
 	)
 
@@ -112,15 +170,22 @@ func (m *MovieModel) Update(movie *Movie) error {
 	// use optimistic locking for updating to prevent race conditions
 	// https://stackoverflow.com/questions/129329/optimistic-vs-pessimistic-locking/129397#129397
 	stmt := `UPDATE movies
-  SET title = $1, year = $2, runtime = $3, genres = $4, version = uuid_generate_v4()
-  WHERE id = $5 AND version = $6
-  RETURNING version`
+  SET title = $1, year = $2, runtime = $3, genres = $4, plot = $5, tagline = $6,
+    original_language = $7, countries = $8, homepage = $9, version = uuid_generate_v4(),
+    updated_at = NOW(), change_seq = nextval('movies_change_seq'), change_xid = pg_current_xact_id()
+  WHERE id = $10 AND version = $11
+  RETURNING version, updated_at`
 
 	args := []any{
 		movie.Title,
 		movie.Year,
 		movie.Runtime,
 		pq.Array(movie.Genres),
+		movie.Plot,
+		movie.Tagline,
+		movie.Language,
+		pq.Array(movie.Countries),
+		movie.Homepage,
 		movie.ID,
 		movie.Version,
 	}
@@ -131,7 +196,7 @@ func (m *MovieModel) Update(movie *Movie) error {
 
 	// execute query with timeout
 	// if no matching rows (sql.ErrNoRows), that means the movie version has changed or record has been deleted
-	err := m.DB.QueryRowContext(ctx, stmt, args...).Scan(&movie.Version)
+	err := m.DB.QueryRowContext(ctx, stmt, args...).Scan(&movie.Version, &movie.UpdatedAt)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -149,8 +214,14 @@ func (m *MovieModel) Delete(id int64) error {
 		return ErrRecordNotFound
 	}
 
-	stmt := `DELETE FROM movies
-  WHERE id = $1`
+	// leave a tombstone behind so delta sync clients learn about the delete
+	stmt := `WITH deleted AS (
+    DELETE FROM movies
+    WHERE id = $1
+    RETURNING id
+  )
+  INSERT INTO movie_tombstones (movie_id)
+  SELECT id FROM deleted`
 
 	// context with 3 second timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -174,26 +245,35 @@ func (m *MovieModel) Delete(id int64) error {
 	return nil
 }
 
-func (m *MovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+func (m *MovieModel) GetAll(title string, genres []string, expr *FilterExpr, filters Filters) ([]*Movie, Metadata, error) {
+	// new array to hold arguments to query
+	// filter expression placeholders are numbered after title and genres
+	args := []any{title, genres}
+	where := expr.SQL(&args)
+	args = append(args, filters.limit(), filters.offset())
+
+	conditions := fmt.Sprintf(`(lower(title) LIKE lower('%%' || $1 || '%%') OR $1 = '')
+    AND (genres @> $2 OR $2 = '{}')
+    AND %s`, where)
+
 	// use postgres full-text search for title
 	stmt := fmt.Sprintf(`
-    SELECT count(*) OVER(), id, title, year, runtime, genres, created_at, version
+    SELECT count(*) OVER(), id, title, year, runtime, genres, plot, tagline, original_language, countries, homepage, created_by, created_at, version
     FROM movies
-    WHERE (lower(title) LIKE lower('%%%%' || $1 || '%%%%') OR $1 = '')
-    AND (genres @> $2 OR $2 = '{}')
+    WHERE %s
     ORDER BY %s %s, id ASC
-    LIMIT $3 OFFSET $4
+    LIMIT $%d OFFSET $%d
     `,
+		conditions,
 		filters.sortColumn(),
 		filters.sortDirection(),
+		len(args)-1,
+		len(args),
 	)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	// new array to hold arguments to query
-	args := []any{title, genres, filters.limit(), filters.offset()}
-
 	rows, err := m.DB.QueryContext(ctx, stmt, args...)
 	if err != nil {
 		return nil, Metadata{}, err
@@ -218,6 +298,12 @@ func (m *MovieModel) GetAll(title string, genres []string, filters Filters) ([]*
 			&movie.Year,
 			&movie.Runtime,
 			pq.Array(&movie.Genres),
+			&movie.Plot,
+			&movie.Tagline,
+			&movie.Language,
+			pq.Array(&movie.Countries),
+			&movie.Homepage,
+			&movie.CreatedBy,
 			&movie.CreatedAt,
 			&movie.Version,
 		)
@@ -234,8 +320,202 @@ func (m *MovieModel) GetAll(title string, genres []string, filters Filters) ([]*
 		return nil, Metadata{}, err
 	}
 
+	// a page past the end has no rows to carry the window count, so count the
+	// matches separately to tell it apart from a search that matched nothing
+	if len(movies) == 0 && filters.Page > 1 {
+		countStmt := fmt.Sprintf(`SELECT count(*) FROM movies WHERE %s`, conditions)
+
+		err = m.DB.QueryRowContext(ctx, countStmt, args[:len(args)-2]...).Scan(&totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+	}
+
 	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
 
 	// if nothing goes wrong, return movie slice
 	return movies, metadata, nil
 }
+
+// lightweight movie used by typeahead
+type MovieSuggestion struct {
+	ID    int64  `json:"id"`
+	Title string `json:"title"`
+	Year  int32  `json:"year,omitempty"`
+}
+
+// escape LIKE wildcards so user input is matched literally
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func (m *MovieModel) Suggest(prefix string, limit int) ([]*MovieSuggestion, error) {
+	prefix = strings.ToLower(prefix)
+
+	// titles that start with the prefix come first, they're matched using the
+	// text_pattern_ops index and ordering with ~<~ lets postgres walk that same
+	// index instead of sorting
+	stmt := `SELECT id, title, year
+    FROM movies
+    WHERE lower(title) LIKE $1 || '%'
+    ORDER BY lower(title) USING ~<~
+    LIMIT $2`
+
+	// typeahead should be fast, give up early rather than queue up requests
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	suggestions, err := m.querySuggestions(ctx, stmt, likeEscaper.Replace(prefix), limit)
+	if err != nil {
+		return nil, err
+	}
+
+	// trigram index needs at least 3 characters to be useful, so short prefixes
+	// only get the titles that start with them
+	if len(suggestions) == limit || utf8.RuneCountInString(prefix) < 3 {
+		return suggestions, nil
+	}
+
+	ids := make([]int64, len(suggestions))
+	for i, s := range suggestions {
+		ids[i] = s.ID
+	}
+
+	// fill the rest with titles containing the prefix anywhere, the gist
+	// trigram index hands back the nearest ones by distance so postgres stops
+	// after the limit instead of sorting every match
+	stmt = `SELECT id, title, year
+    FROM movies
+    WHERE lower(title) LIKE '%' || $1 || '%'
+    AND id <> ALL($2)
+    ORDER BY lower(title) <-> $3
+    LIMIT $4`
+
+	// distance is computed against the unescaped prefix
+	rest, err := m.querySuggestions(ctx, stmt, likeEscaper.Replace(prefix), pq.Array(ids), prefix, limit-len(suggestions))
+	if err != nil {
+		return nil, err
+	}
+
+	return append(suggestions, rest...), nil
+}
+
+func (m *MovieModel) querySuggestions(ctx context.Context, stmt string, args ...any) ([]*MovieSuggestion, error) {
+	rows, err := m.DB.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suggestions := []*MovieSuggestion{}
+
+	for rows.Next() {
+		var s MovieSuggestion
+
+		err := rows.Scan(&s.ID, &s.Title, &s.Year)
+		if err != nil {
+			return nil, err
+		}
+
+		suggestions = append(suggestions, &s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return suggestions, nil
+}
+
+// corrected search terms offered when a search comes back empty
+type SearchCorrections struct {
+	Titles []string `json:"titles,omitempty"`
+	Genres []string `json:"genres,omitempty"`
+}
+
+// returns true if there's nothing to suggest
+func (c *SearchCorrections) Empty() bool {
+	return len(c.Titles) == 0 && len(c.Genres) == 0
+}
+
+// Corrections looks for titles and genres similar to the ones searched for
+// using pg_trgm, only meant to be called after a search returns zero rows
+func (m *MovieModel) Corrections(title string, genres []string) (*SearchCorrections, error) {
+	corrections := &SearchCorrections{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if title != "" {
+		// word similarity compares the search against the closest part of
+		// each title, so "godfathr" still matches "The Godfather Part II"
+		// the <% operator uses the trigram index on lower(title)
+		stmt := `SELECT title
+    FROM movies
+    WHERE lower($1) <% lower(title)
+    GROUP BY title
+    ORDER BY max(word_similarity(lower($1), lower(title))) DESC, title ASC
+    LIMIT 5`
+
+		rows, err := m.DB.QueryContext(ctx, stmt, title)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var t string
+
+			err := rows.Scan(&t)
+			if err != nil {
+				return nil, err
+			}
+
+			corrections.Titles = append(corrections.Titles, t)
+		}
+
+		if err = rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(genres) > 0 {
+		// replace every genre that no movie has with the closest existing genre
+		// NOTE: this scans the distinct genres of the whole table, which is fine
+		// since it only runs when a search has already come back empty
+		stmt := `SELECT coalesce((
+      SELECT genre
+      FROM (SELECT DISTINCT unnest(genres) AS genre FROM movies) AS g
+      WHERE similarity(lower(genre), lower($1)) > 0.3
+      ORDER BY similarity(lower(genre), lower($1)) DESC, genre ASC
+      LIMIT 1
+    ), '')
+    WHERE NOT EXISTS (SELECT 1 FROM movies WHERE genres @> ARRAY[$1::text])`
+
+		corrected := make([]string, 0, len(genres))
+		changed := false
+
+		for _, genre := range genres {
+			var match string
+
+			err := m.DB.QueryRowContext(ctx, stmt, genre).Scan(&match)
+			switch {
+			// genre exists, keep it as is
+			case errors.Is(err, sql.ErrNoRows):
+				corrected = append(corrected, genre)
+			case err != nil:
+				return nil, err
+			// genre doesn't exist and nothing is close enough, drop it
+			case match == "":
+				changed = true
+			default:
+				corrected = append(corrected, match)
+				changed = true
+			}
+		}
+
+		if changed && len(corrected) > 0 {
+			corrections.Genres = corrected
+		}
+	}
+
+	return corrections, nil
+}
@@ -0,0 +1,325 @@
+package data
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/V4N1LLA-1CE/movie-db-api/internal/breached"
+	"github.com/V4N1LLA-1CE/movie-db-api/internal/validator"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	PasswordHashArgon2id = "argon2id"
+	PasswordHashBcrypt   = "bcrypt"
+)
+
+// bcrypt ignores anything past 72 bytes, argon2id has no such limit so the
+// cap is only there to stop huge passwords being used to burn cpu
+const (
+	bcryptMaxPasswordLength   = 72
+	argon2idMaxPasswordLength = 1024
+)
+
+const (
+	argon2idSaltLength = 16
+	argon2idKeyLength  = 32
+)
+
+var errInvalidPasswordHash = errors.New("invalid password hash")
+
+// PasswordHashParams is the policy new password hashes are created with
+// existing hashes keep whatever they were created with, see NeedsRehash
+type PasswordHashParams struct {
+	Algorithm   string
+	Memory      uint32 // argon2id memory in KiB
+	Iterations  uint32 // argon2id passes over the memory
+	Parallelism uint8  // argon2id threads
+	BcryptCost  int
+}
+
+// the second recommended option from RFC 9106 with fewer threads
+var DefaultPasswordHashParams = PasswordHashParams{
+	Algorithm:   PasswordHashArgon2id,
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	BcryptCost:  12,
+}
+
+var passwordHashParams = DefaultPasswordHashParams
+
+// new passwords can't be on this list, nil skips the check
+var breachedPasswords *breached.List
+
+// sets the policy for new password hashes, must be called before serving requests
+func SetPasswordHashParams(params PasswordHashParams) error {
+	switch params.Algorithm {
+	case PasswordHashArgon2id:
+		if params.Memory < 8*uint32(params.Parallelism) || params.Iterations < 1 || params.Parallelism < 1 {
+			return errors.New("argon2id needs at least 1 iteration, 1 thread and 8 KiB of memory per thread")
+		}
+	case PasswordHashBcrypt:
+		if params.BcryptCost < bcrypt.MinCost || params.BcryptCost > bcrypt.MaxCost {
+			return fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+	default:
+		return fmt.Errorf("unsupported password hash algorithm %q", params.Algorithm)
+	}
+
+	passwordHashParams = params
+
+	return nil
+}
+
+// sets the list new passwords are checked against, must be called before serving requests
+func SetBreachedPasswords(list *breached.List) {
+	breachedPasswords = list
+}
+
+// use pointer to distinguish between nil and "" when there's no password
+type password struct {
+	plaintext *string
+	hash      []byte
+}
+
+func (p *password) Set(plaintext string) error {
+	hash, err := hashPassword(plaintext, passwordHashParams)
+	if err != nil {
+		return err
+	}
+
+	p.plaintext = &plaintext
+	p.hash = hash
+
+	return nil
+}
+
+// replaces the hash with one nobody knows the password for, used to force a
+// reset. The plaintext is left unset so it isn't validated
+func (p *password) Scramble() error {
+	randomBytes := make([]byte, 32)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return err
+	}
+
+	hash, err := hashPassword(base64.RawStdEncoding.EncodeToString(randomBytes), passwordHashParams)
+	if err != nil {
+		return err
+	}
+
+	p.plaintext = nil
+	p.hash = hash
+
+	return nil
+}
+
+// checks plaintext against the hash, whichever algorithm it was created with
+func (p *password) Matches(plaintext string) (bool, error) {
+	if isArgon2idHash(p.hash) {
+		params, salt, key, err := decodeArgon2idHash(p.hash)
+		if err != nil {
+			return false, err
+		}
+
+		other := argon2.IDKey([]byte(plaintext), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+
+		return subtle.ConstantTimeCompare(key, other) == 1, nil
+	}
+
+	// bcrypt hashes were only ever created for passwords it could handle
+	if len(plaintext) > bcryptMaxPasswordLength {
+		return false, nil
+	}
+
+	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plaintext))
+	if err != nil {
+		switch {
+		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// replaces the hash with one under the current policy, the caller has to have
+// checked plaintext with Matches first. Returns the old hash
+func (p *password) Rehash(plaintext string) ([]byte, error) {
+	old := p.hash
+
+	err := p.Set(plaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	return old, nil
+}
+
+// whether the hash was created with a different algorithm or parameters to the
+// current policy. It can only be replaced once the plaintext is known, i.e.
+// right after a successful Matches
+func (p *password) NeedsRehash() bool {
+	if isArgon2idHash(p.hash) {
+		if passwordHashParams.Algorithm != PasswordHashArgon2id {
+			return true
+		}
+
+		params, _, _, err := decodeArgon2idHash(p.hash)
+		if err != nil {
+			return true
+		}
+
+		return params.Memory != passwordHashParams.Memory ||
+			params.Iterations != passwordHashParams.Iterations ||
+			params.Parallelism != passwordHashParams.Parallelism
+	}
+
+	if passwordHashParams.Algorithm != PasswordHashBcrypt {
+		return true
+	}
+
+	cost, err := bcrypt.Cost(p.hash)
+	return err != nil || cost != passwordHashParams.BcryptCost
+}
+
+func hashPassword(plaintext string, params PasswordHashParams) ([]byte, error) {
+	if params.Algorithm == PasswordHashBcrypt {
+		return bcrypt.GenerateFromPassword([]byte(plaintext), params.BcryptCost)
+	}
+
+	salt := make([]byte, argon2idSaltLength)
+
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	key := argon2.IDKey([]byte(plaintext), salt, params.Iterations, params.Memory, params.Parallelism, argon2idKeyLength)
+
+	// PHC string format, the same as the argon2 reference implementation
+	// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>
+	encoded := fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		params.Memory,
+		params.Iterations,
+		params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	)
+
+	return []byte(encoded), nil
+}
+
+func isArgon2idHash(hash []byte) bool {
+	return strings.HasPrefix(string(hash), "$argon2id$")
+}
+
+func decodeArgon2idHash(hash []byte) (PasswordHashParams, []byte, []byte, error) {
+	params := PasswordHashParams{Algorithm: PasswordHashArgon2id}
+
+	// "", "argon2id", "v=19", "m=65536,t=3,p=2", salt, key
+	parts := strings.Split(string(hash), "$")
+	if len(parts) != 6 {
+		return params, nil, nil, errInvalidPasswordHash
+	}
+
+	var version int
+
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return params, nil, nil, errInvalidPasswordHash
+	}
+
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism)
+	if err != nil {
+		return params, nil, nil, errInvalidPasswordHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, errInvalidPasswordHash
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, errInvalidPasswordHash
+	}
+
+	return params, salt, key, nil
+}
+
+// hash of a throwaway password under the current policy, used so logins for
+// emails without an account take as long as ones with a wrong password
+var dummyPassword = struct {
+	once sync.Once
+	password
+}{}
+
+// does the same work as Matches without a user to compare against
+func DummyPasswordMatch(plaintext string) {
+	dummyPassword.once.Do(func() {
+		err := dummyPassword.password.Scramble()
+		if err != nil {
+			panic(err)
+		}
+	})
+
+	_, _ = dummyPassword.password.Matches(plaintext)
+}
+
+func ValidatePasswordPlaintext(v *validator.Validator, password string) {
+	maxLength := argon2idMaxPasswordLength
+	if passwordHashParams.Algorithm == PasswordHashBcrypt {
+		maxLength = bcryptMaxPasswordLength
+	}
+
+	v.Check(password != "", "password", "must be provided")
+	v.Check(len(password) >= 8, "password", "must be at least 8 characters long")
+	v.Check(len(password) <= maxLength, "password", fmt.Sprintf("must not exceed %d characters long", maxLength))
+}
+
+// checks a password someone wants to start using, on top of the length checks
+// it can't be a known breached password or contain the user's name or email
+// logins only use ValidatePasswordPlaintext so existing passwords keep working
+func ValidateNewPassword(v *validator.Validator, password string, user *User) {
+	ValidatePasswordPlaintext(v, password)
+
+	if breachedPasswords != nil && breachedPasswords.Contains(password) {
+		v.AddError("password", "is too common, it has appeared in data breaches so please choose a different one")
+		return
+	}
+
+	if containsPersonalInfo(password, user) {
+		v.AddError("password", "must not contain your name or email address")
+	}
+}
+
+// parts of the name and email shorter than this are too likely to turn up by chance
+const minPersonalInfoLength = 4
+
+func containsPersonalInfo(password string, user *User) bool {
+	password = strings.ToLower(password)
+
+	local, _, _ := strings.Cut(strings.ToLower(user.Email), "@")
+
+	candidates := append(strings.Fields(strings.ToLower(user.Name)), local)
+
+	for _, c := range candidates {
+		if len(c) >= minPersonalInfoLength && strings.Contains(password, c) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,243 @@
+package data
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// cheap enough to keep the tests fast, the format is the same at any cost
+var (
+	testArgon2idParams = PasswordHashParams{Algorithm: PasswordHashArgon2id, Memory: 1024, Iterations: 1, Parallelism: 1}
+	testBcryptParams   = PasswordHashParams{Algorithm: PasswordHashBcrypt, BcryptCost: bcrypt.MinCost}
+)
+
+// sets the hashing policy for the rest of the test
+func setPasswordHashParams(t *testing.T, params PasswordHashParams) {
+	t.Helper()
+
+	old := passwordHashParams
+	t.Cleanup(func() { passwordHashParams = old })
+
+	err := SetPasswordHashParams(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPasswordArgon2idRoundTrip(t *testing.T) {
+	setPasswordHashParams(t, testArgon2idParams)
+
+	var p password
+
+	err := p.Set("pa55word123")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(string(p.hash), "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Fatalf("hash = %q, want an argon2id PHC string with the policy's parameters", p.hash)
+	}
+
+	params, salt, key, err := decodeArgon2idHash(p.hash)
+	if err != nil {
+		t.Fatalf("decoding own hash: %v", err)
+	}
+
+	if params != testArgon2idParams {
+		t.Errorf("decoded params = %+v, want %+v", params, testArgon2idParams)
+	}
+
+	if len(salt) != argon2idSaltLength || len(key) != argon2idKeyLength {
+		t.Errorf("salt is %d bytes and key %d, want %d and %d", len(salt), len(key), argon2idSaltLength, argon2idKeyLength)
+	}
+
+	tests := []struct {
+		plaintext string
+		want      bool
+	}{
+		{"pa55word123", true},
+		{"pa55word124", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		got, err := p.Matches(tt.plaintext)
+		if err != nil {
+			t.Fatalf("Matches(%q): %v", tt.plaintext, err)
+		}
+
+		if got != tt.want {
+			t.Errorf("Matches(%q) = %t, want %t", tt.plaintext, got, tt.want)
+		}
+	}
+}
+
+func TestPasswordMatchesBcrypt(t *testing.T) {
+	// hashes created before argon2id keep working whatever the policy is now
+	setPasswordHashParams(t, testArgon2idParams)
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("pa55word123"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := password{hash: hash}
+
+	for plaintext, want := range map[string]bool{"pa55word123": true, "pa55word124": false} {
+		got, err := p.Matches(plaintext)
+		if err != nil {
+			t.Fatalf("Matches(%q): %v", plaintext, err)
+		}
+
+		if got != want {
+			t.Errorf("Matches(%q) = %t, want %t", plaintext, got, want)
+		}
+	}
+}
+
+func TestPasswordMatchesLongPasswordAgainstBcrypt(t *testing.T) {
+	longest := strings.Repeat("a", bcryptMaxPasswordLength)
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(longest), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := password{hash: hash}
+
+	got, err := p.Matches(longest)
+	if err != nil || !got {
+		t.Fatalf("Matches(72 bytes) = %t, %v, want true", got, err)
+	}
+
+	// bcrypt would only look at the first 72 bytes and accept this
+	got, err = p.Matches(longest + "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got {
+		t.Error("a password over 72 bytes matched a bcrypt hash of its first 72 bytes")
+	}
+}
+
+func TestPasswordNeedsRehash(t *testing.T) {
+	setPasswordHashParams(t, testArgon2idParams)
+
+	var argon2id password
+
+	err := argon2id.Set("pa55word123")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	setPasswordHashParams(t, testBcryptParams)
+
+	var bcryptHash password
+
+	err = bcryptHash.Set("pa55word123")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	moreMemory := testArgon2idParams
+	moreMemory.Memory *= 2
+
+	moreIterations := testArgon2idParams
+	moreIterations.Iterations++
+
+	moreThreads := testArgon2idParams
+	moreThreads.Parallelism++
+
+	higherCost := testBcryptParams
+	higherCost.BcryptCost++
+
+	tests := []struct {
+		name   string
+		hash   password
+		policy PasswordHashParams
+		want   bool
+	}{
+		{"argon2id, same policy", argon2id, testArgon2idParams, false},
+		{"argon2id, more memory", argon2id, moreMemory, true},
+		{"argon2id, more iterations", argon2id, moreIterations, true},
+		{"argon2id, more threads", argon2id, moreThreads, true},
+		{"argon2id, policy is bcrypt", argon2id, testBcryptParams, true},
+		{"bcrypt, same policy", bcryptHash, testBcryptParams, false},
+		{"bcrypt, higher cost", bcryptHash, higherCost, true},
+		{"bcrypt, policy is argon2id", bcryptHash, testArgon2idParams, true},
+		{"malformed argon2id", password{hash: []byte("$argon2id$v=19$m=1024")}, testArgon2idParams, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setPasswordHashParams(t, tt.policy)
+
+			if got := tt.hash.NeedsRehash(); got != tt.want {
+				t.Errorf("NeedsRehash() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeArgon2idHashMalformed(t *testing.T) {
+	const (
+		salt = "c29tZXNhbHRzb21lc2FsdA"
+		key  = "a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2U"
+	)
+
+	valid := "$argon2id$v=19$m=1024,t=1,p=1$" + salt + "$" + key
+
+	_, _, _, err := decodeArgon2idHash([]byte(valid))
+	if err != nil {
+		t.Fatalf("decoding a valid hash: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		hash string
+	}{
+		{"missing key", "$argon2id$v=19$m=1024,t=1,p=1$" + salt},
+		{"extra part", valid + "$extra"},
+		{"missing version", "$argon2id$m=1024,t=1,p=1$" + salt + "$" + key + "$"},
+		{"older version", "$argon2id$v=16$m=1024,t=1,p=1$" + salt + "$" + key},
+		{"params not numbers", "$argon2id$v=19$m=x,t=1,p=1$" + salt + "$" + key},
+		{"params out of order", "$argon2id$v=19$t=1,m=1024,p=1$" + salt + "$" + key},
+		{"parallelism overflows", "$argon2id$v=19$m=1024,t=1,p=256$" + salt + "$" + key},
+		{"salt not base64", "$argon2id$v=19$m=1024,t=1,p=1$not*base64$" + key},
+		{"key not base64", "$argon2id$v=19$m=1024,t=1,p=1$" + salt + "$not*base64"},
+		{"empty key", "$argon2id$v=19$m=1024,t=1,p=1$" + salt + "$"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, _, err := decodeArgon2idHash([]byte(tt.hash))
+			if !errors.Is(err, errInvalidPasswordHash) {
+				t.Errorf("err = %v, want %v", err, errInvalidPasswordHash)
+			}
+
+			// Matches has to fail rather than compare against a half parsed hash
+			p := password{hash: []byte(tt.hash)}
+
+			ok, err := p.Matches("pa55word123")
+			if err == nil || ok {
+				t.Errorf("Matches() = %t, %v, want an error", ok, err)
+			}
+		})
+	}
+}
+
+func TestPasswordMatchesUnknownHash(t *testing.T) {
+	// anything that isn't argon2id is handed to bcrypt, which has to reject it
+	for _, hash := range []string{"", "plaintext", "$2a$04$short"} {
+		p := password{hash: []byte(hash)}
+
+		ok, err := p.Matches("pa55word123")
+		if err == nil || ok {
+			t.Errorf("Matches() against %q = %t, %v, want an error", hash, ok, err)
+		}
+	}
+}
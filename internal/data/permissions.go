@@ -3,6 +3,7 @@ package data
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"slices"
 	"time"
 
@@ -15,27 +16,66 @@ func (p *Permissions) Include(code string) bool {
 	return slices.Contains(*p, code)
 }
 
+// a user holding a permission or role, and where it comes from
+type Grantee struct {
+	UserID int64    `json:"user_id"`
+	Name   string   `json:"name"`
+	Email  string   `json:"email"`
+	Direct bool     `json:"direct"` // granted to the user directly rather than through a role
+	Roles  []string `json:"roles"`
+}
+
 type PermissionModel struct {
 	DB *sql.DB
 }
 
+// returns every permission code that exists
+func (m *PermissionModel) GetAll() (Permissions, error) {
+	stmt := `SELECT code
+  FROM permissions
+  ORDER BY code`
+
+	return m.query(stmt)
+}
+
+// returns the user's effective permissions, granted directly or through any of their roles
 func (m *PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 	stmt := `SELECT permissions.code
   FROM permissions
   INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
-  INNER JOIN users ON users_permissions.user_id = users.id
-  WHERE users.id = $1`
+  WHERE users_permissions.user_id = $1
+  UNION
+  SELECT permissions.code
+  FROM permissions
+  INNER JOIN roles_permissions ON roles_permissions.permission_id = permissions.id
+  INNER JOIN users_roles ON users_roles.role_id = roles_permissions.role_id
+  WHERE users_roles.user_id = $1`
+
+	return m.query(stmt, userID)
+}
+
+// returns only the permissions granted to the user directly
+func (m *PermissionModel) GetDirectForUser(userID int64) (Permissions, error) {
+	stmt := `SELECT permissions.code
+  FROM permissions
+  INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
+  WHERE users_permissions.user_id = $1
+  ORDER BY permissions.code`
+
+	return m.query(stmt, userID)
+}
 
+func (m *PermissionModel) query(stmt string, args ...any) (Permissions, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	rows, err := m.DB.QueryContext(ctx, stmt, userID)
+	rows, err := m.DB.QueryContext(ctx, stmt, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var permissions Permissions
+	permissions := Permissions{}
 
 	for rows.Next() {
 		var p string
@@ -55,16 +95,109 @@ func (m *PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 	return permissions, nil
 }
 
+// grants permissions to a user directly, codes the user already has are skipped
 func (m *PermissionModel) AddForUser(userID int64, codes ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return addPermissionsForUser(ctx, m.DB, userID, codes)
+}
+
+// shared by AddForUser and InvitationModel.Accept
+func addPermissionsForUser(ctx context.Context, db execer, userID int64, codes []string) error {
 	// find permissions that matches values in code
 	// for each match, create a new record in users_permissions for
 	// user_id  |  permission_id
 	stmt := `INSERT INTO users_permissions
-  SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
+  SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+  ON CONFLICT DO NOTHING`
+
+	_, err := db.ExecContext(ctx, stmt, userID, pq.Array(codes))
+	return err
+}
+
+// removes a direct grant, permissions the user gets through a role are unaffected
+// returns ErrRecordNotFound if the user wasn't granted the permission directly
+func (m *PermissionModel) DeleteForUser(userID int64, code string) error {
+	stmt := `DELETE FROM users_permissions
+  USING permissions
+  WHERE users_permissions.permission_id = permissions.id
+  AND users_permissions.user_id = $1
+  AND permissions.code = $2`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := m.DB.ExecContext(ctx, stmt, userID, pq.Array(codes))
-	return err
+	result, err := m.DB.ExecContext(ctx, stmt, userID, code)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// returns the users holding a permission, either directly or through a role
+func (m *PermissionModel) GetHolders(code string, filters Filters) ([]*Grantee, Metadata, error) {
+	stmt := fmt.Sprintf(`SELECT count(*) OVER(), users.id, users.name, users.email,
+    bool_or(grants.role IS NULL),
+    coalesce(array_agg(grants.role ORDER BY grants.role) FILTER (WHERE grants.role IS NOT NULL), '{}')
+  FROM (
+    SELECT users_permissions.user_id, NULL::text AS role
+    FROM users_permissions
+    INNER JOIN permissions ON permissions.id = users_permissions.permission_id
+    WHERE permissions.code = $1
+    UNION ALL
+    SELECT users_roles.user_id, roles.name
+    FROM users_roles
+    INNER JOIN roles ON roles.id = users_roles.role_id
+    INNER JOIN roles_permissions ON roles_permissions.role_id = roles.id
+    INNER JOIN permissions ON permissions.id = roles_permissions.permission_id
+    WHERE permissions.code = $1
+  ) AS grants
+  INNER JOIN users ON users.id = grants.user_id
+  GROUP BY users.id
+  ORDER BY users.%s %s, users.id ASC
+  LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	return queryGrantees(m.DB, stmt, filters, code, filters.limit(), filters.offset())
+}
+
+func queryGrantees(db *sql.DB, stmt string, filters Filters, args ...any) ([]*Grantee, Metadata, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	grantees := []*Grantee{}
+
+	for rows.Next() {
+		var g Grantee
+
+		err := rows.Scan(&totalRecords, &g.UserID, &g.Name, &g.Email, &g.Direct, pq.Array(&g.Roles))
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		grantees = append(grantees, &g)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return grantees, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
 }
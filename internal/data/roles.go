@@ -0,0 +1,236 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/V4N1LLA-1CE/movie-db-api/internal/validator"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/lib/pq"
+)
+
+// role given to every new user
+const DefaultRole = "viewer"
+
+var (
+	ErrDuplicateRole = errors.New("duplicate role")
+
+	RoleNameRX = regexp.MustCompile(`^[a-z0-9_-]+$`)
+)
+
+// Role is a named set of permissions that can be assigned to users
+type Role struct {
+	ID          int64       `json:"id"`
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	Permissions Permissions `json:"permissions"`
+}
+
+// known is every permission that exists, roles can't reference anything else
+func ValidateRole(v *validator.Validator, role *Role, known Permissions) {
+	v.Check(role.Name != "", "name", "must be provided")
+	v.Check(len(role.Name) <= 50, "name", "must not be more than 50 characters long")
+	v.Check(validator.Matches(role.Name, RoleNameRX), "name", "must only contain lowercase letters, digits, dashes and underscores")
+	v.Check(len(role.Description) <= 500, "description", "must not be more than 500 characters long")
+
+	v.Check(role.Permissions != nil, "permissions", "must be provided")
+	v.Check(validator.Unique(role.Permissions), "permissions", "must not contain duplicate values")
+
+	for _, p := range role.Permissions {
+		if !known.Include(p) {
+			v.AddError("permissions", strconv.Quote(p)+" is not a known permission")
+			break
+		}
+	}
+}
+
+type RoleModel struct {
+	DB *sql.DB
+}
+
+// returns every role along with the permissions it grants
+func (m *RoleModel) GetAll() ([]*Role, error) {
+	stmt := `SELECT roles.id, roles.name, roles.description,
+    coalesce(array_agg(permissions.code ORDER BY permissions.code) FILTER (WHERE permissions.code IS NOT NULL), '{}')
+  FROM roles
+  LEFT JOIN roles_permissions ON roles_permissions.role_id = roles.id
+  LEFT JOIN permissions ON permissions.id = roles_permissions.permission_id
+  GROUP BY roles.id
+  ORDER BY roles.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, stmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []*Role{}
+
+	for rows.Next() {
+		var role Role
+
+		err := rows.Scan(&role.ID, &role.Name, &role.Description, pq.Array((*[]string)(&role.Permissions)))
+		if err != nil {
+			return nil, err
+		}
+
+		roles = append(roles, &role)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+// creates a role and links it to its permissions
+func (m *RoleModel) Insert(role *Role) error {
+	insertStmt := `INSERT INTO roles (name, description)
+  VALUES ($1, $2)
+  RETURNING id`
+
+	grantStmt := `INSERT INTO roles_permissions
+  SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, insertStmt, role.Name, role.Description).Scan(&role.ID)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		switch {
+		case errors.As(err, &pgErr) && pgErr.Code == "23505":
+			return ErrDuplicateRole
+		default:
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, grantStmt, role.ID, pq.Array([]string(role.Permissions)))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// returns the names of the roles assigned to a user
+func (m *RoleModel) GetAllForUser(userID int64) ([]string, error) {
+	stmt := `SELECT roles.name
+  FROM roles
+  INNER JOIN users_roles ON users_roles.role_id = roles.id
+  WHERE users_roles.user_id = $1
+  ORDER BY roles.name`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := []string{}
+
+	for rows.Next() {
+		var name string
+
+		err := rows.Scan(&name)
+		if err != nil {
+			return nil, err
+		}
+
+		names = append(names, name)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return names, nil
+}
+
+// assigns a role to a user, assigning a role they already have is a no-op
+// returns ErrRecordNotFound if the role doesn't exist
+func (m *RoleModel) AddForUser(userID int64, name string) error {
+	stmt := `WITH role AS (
+    SELECT id FROM roles WHERE name = $2
+  ), assigned AS (
+    INSERT INTO users_roles SELECT $1, role.id FROM role
+    ON CONFLICT DO NOTHING
+  )
+  SELECT EXISTS (SELECT 1 FROM role)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var exists bool
+
+	err := m.DB.QueryRowContext(ctx, stmt, userID, name).Scan(&exists)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// returns ErrRecordNotFound if the user doesn't have the role
+func (m *RoleModel) DeleteForUser(userID int64, name string) error {
+	stmt := `DELETE FROM users_roles
+  USING roles
+  WHERE users_roles.role_id = roles.id
+  AND users_roles.user_id = $1
+  AND roles.name = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, stmt, userID, name)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// returns the users a role is assigned to
+func (m *RoleModel) GetHolders(name string, filters Filters) ([]*Grantee, Metadata, error) {
+	stmt := fmt.Sprintf(`SELECT count(*) OVER(), users.id, users.name, users.email, false, ARRAY[roles.name]
+  FROM users
+  INNER JOIN users_roles ON users_roles.user_id = users.id
+  INNER JOIN roles ON roles.id = users_roles.role_id
+  WHERE roles.name = $1
+  ORDER BY users.%s %s, users.id ASC
+  LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	return queryGrantees(m.DB, stmt, filters, name, filters.limit(), filters.offset())
+}
@@ -0,0 +1,168 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// security event types
+const (
+	EventLogin             = "login"
+	EventLoginMFA          = "login.mfa"
+	EventLoginMagicLink    = "login.magic_link"
+	EventAuthentication    = "authentication" // a request with a bad token or api key
+	EventUserActivated     = "user.activated"
+	EventUserDeactivated   = "user.deactivated"
+	EventAPIKeyCreated     = "api_key.created"
+	EventAPIKeyRevoked     = "api_key.revoked"
+	EventPermissionGranted = "permission.granted"
+	EventPermissionRevoked = "permission.revoked"
+	EventRoleAssigned      = "role.assigned"
+	EventRoleRevoked       = "role.revoked"
+	EventInvitationCreated = "invitation.created"
+	EventInvitationRevoked = "invitation.revoked"
+	EventUserRegistered    = "user.registered"
+)
+
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// SecurityEvent is an entry in the security audit log
+type SecurityEvent struct {
+	ID        int64             `json:"id"`
+	CreatedAt time.Time         `json:"created_at"`
+	Event     string            `json:"event"`
+	Outcome   string            `json:"outcome"`
+	UserID    *int64            `json:"user_id"`            // nil when the user isn't known, i.e. a login for an unknown email
+	ActorID   *int64            `json:"actor_id,omitempty"` // who made the change, when it wasn't the user themselves
+	IP        string            `json:"ip"`
+	UserAgent string            `json:"user_agent"`
+	Details   map[string]string `json:"details,omitempty"` // i.e. the reason for a failure or the permission granted
+}
+
+type SecurityEventSearch struct {
+	UserID  *int64
+	Event   string
+	Outcome string
+	IP      string
+	After   *time.Time
+	Before  *time.Time
+}
+
+type SecurityEventModel struct {
+	DB *sql.DB
+}
+
+func (m *SecurityEventModel) Insert(event *SecurityEvent) error {
+	stmt := `INSERT INTO security_events (event, outcome, user_id, actor_id, ip, user_agent, details)
+  VALUES ($1, $2, $3, $4, $5, $6, $7)
+  RETURNING id, created_at`
+
+	details, err := json.Marshal(event.Details)
+	if err != nil {
+		return err
+	}
+
+	// nil details marshal to null, which the column doesn't allow
+	if event.Details == nil {
+		details = []byte("{}")
+	}
+
+	args := []any{event.Event, event.Outcome, event.UserID, event.ActorID, event.IP, event.UserAgent, details}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, stmt, args...).Scan(&event.ID, &event.CreatedAt)
+}
+
+func (m *SecurityEventModel) GetAll(search SecurityEventSearch, filters Filters) ([]*SecurityEvent, Metadata, error) {
+	stmt := fmt.Sprintf(`SELECT count(*) OVER(), id, created_at, event, outcome, user_id, actor_id, ip, user_agent, details
+  FROM security_events
+  WHERE (user_id = $1 OR $1::bigint IS NULL)
+  AND (event = $2 OR $2 = '')
+  AND (outcome = $3 OR $3 = '')
+  AND (ip = $4 OR $4 = '')
+  AND (created_at >= $5 OR $5::timestamptz IS NULL)
+  AND (created_at < $6 OR $6::timestamptz IS NULL)
+  ORDER BY %s %s, id DESC
+  LIMIT $7 OFFSET $8`, filters.sortColumn(), filters.sortDirection())
+
+	args := []any{
+		search.UserID,
+		search.Event,
+		search.Outcome,
+		search.IP,
+		search.After,
+		search.Before,
+		filters.limit(),
+		filters.offset(),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	events := []*SecurityEvent{}
+
+	for rows.Next() {
+		var event SecurityEvent
+		var details []byte
+
+		err := rows.Scan(
+			&totalRecords,
+			&event.ID,
+			&event.CreatedAt,
+			&event.Event,
+			&event.Outcome,
+			&event.UserID,
+			&event.ActorID,
+			&event.IP,
+			&event.UserAgent,
+			&details,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		err = json.Unmarshal(details, &event.Details)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		events = append(events, &event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return events, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// removes events older than the retention period
+func (m *SecurityEventModel) DeleteOlderThan(retention time.Duration) (int64, error) {
+	stmt := `DELETE FROM security_events
+  WHERE created_at < $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, stmt, time.Now().Add(-retention))
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
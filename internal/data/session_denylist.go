@@ -0,0 +1,67 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// sessions land on the deny list through a trigger whenever their tokens are
+// deleted, see the session_denylist migration
+type SessionDenylistModel struct {
+	DB *sql.DB
+}
+
+// returns sessions revoked after since along with when they were revoked
+func (m *SessionDenylistModel) GetRevokedSince(since time.Time) (map[uuid.UUID]time.Time, error) {
+	stmt := `SELECT family_id, revoked_at
+  FROM session_denylist
+  WHERE revoked_at >= $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, stmt, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revoked := make(map[uuid.UUID]time.Time)
+
+	for rows.Next() {
+		var familyID uuid.UUID
+		var revokedAt time.Time
+
+		err := rows.Scan(&familyID, &revokedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		revoked[familyID] = revokedAt
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return revoked, nil
+}
+
+// removes entries old enough that every access token they could block has expired
+func (m *SessionDenylistModel) DeleteOlderThan(age time.Duration) (int64, error) {
+	stmt := `DELETE FROM session_denylist
+  WHERE revoked_at < $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, stmt, time.Now().Add(-age))
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
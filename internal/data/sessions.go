@@ -0,0 +1,361 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// user agents are client supplied so cap what gets stored
+const userAgentMaxLength = 500
+
+// makes a raw User-Agent header safe to store in a text column, postgres
+// rejects invalid UTF-8 and NUL bytes, and cuts it to userAgentMaxLength
+// bytes without splitting a character
+func CleanUserAgent(userAgent string) string {
+	userAgent = strings.ToValidUTF8(userAgent, "\uFFFD")
+	userAgent = strings.ReplaceAll(userAgent, "\x00", "")
+
+	if len(userAgent) <= userAgentMaxLength {
+		return userAgent
+	}
+
+	cut := userAgentMaxLength
+	for cut > 0 && !utf8.RuneStart(userAgent[cut]) {
+		cut--
+	}
+
+	return userAgent[:cut]
+}
+
+var (
+	ErrRefreshTokenReused = errors.New("refresh token reused")
+)
+
+// Session is a login as shown to its owner, made up of the access and refresh
+// tokens that share a family. The tokens themselves are never returned, only
+// the id used to revoke the session
+type Session struct {
+	ID         int64      `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	Expiry     time.Time  `json:"expiry"`
+	IP         string     `json:"ip"`
+	UserAgent  string     `json:"user_agent"`
+	Current    bool       `json:"current"`
+}
+
+// access and refresh token handed out together on login and on refresh
+type SessionTokens struct {
+	Access  *Token
+	Refresh *Token
+}
+
+// creates a new session for a user, recording where it was issued from
+// an accessTTL of 0 skips the database access token, for when the caller
+// issues signed access tokens instead
+func (m *TokenModel) NewSession(userID int64, accessTTL, refreshTTL time.Duration, ip, userAgent string) (*SessionTokens, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	tokens, err := insertSessionTokens(ctx, tx, userID, uuid.New(), accessTTL, refreshTTL, ip, userAgent)
+	if err != nil {
+		return nil, err
+	}
+
+	return tokens, tx.Commit()
+}
+
+// exchanges a refresh token for a new access and refresh token in the same family
+// accessTTL works the same as for NewSession. Each refresh token works once, presenting one that was already used means it
+// leaked, so the whole family is revoked and ErrRefreshTokenReused is returned
+func (m *TokenModel) RotateSession(refreshPlaintext string, accessTTL, refreshTTL time.Duration, ip, userAgent string) (*SessionTokens, error) {
+	// only one concurrent rotation can mark the token as used
+	useStmt := `UPDATE tokens
+  SET used_at = NOW(), last_used_at = NOW()
+  WHERE hash = $1 AND scope = $2 AND used_at IS NULL AND expiry > NOW()
+  RETURNING user_id, family_id`
+
+	reusedStmt := `DELETE FROM tokens
+  WHERE family_id = (
+    SELECT family_id FROM tokens
+    WHERE hash = $1 AND scope = $2 AND used_at IS NOT NULL
+  )`
+
+	refreshHash := tokenHash(refreshPlaintext)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var userID int64
+	var familyID uuid.UUID
+
+	err = tx.QueryRowContext(ctx, useStmt, refreshHash, ScopeRefresh).Scan(&userID, &familyID)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+
+		// either unknown/expired or already used, revoke the family in the latter case
+		result, err := tx.ExecContext(ctx, reusedStmt, refreshHash, ScopeRefresh)
+		if err != nil {
+			return nil, err
+		}
+
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+
+		if rows > 0 {
+			if err = tx.Commit(); err != nil {
+				return nil, err
+			}
+			return nil, ErrRefreshTokenReused
+		}
+
+		return nil, ErrRecordNotFound
+	}
+
+	tokens, err := insertSessionTokens(ctx, tx, userID, familyID, accessTTL, refreshTTL, ip, userAgent)
+	if err != nil {
+		return nil, err
+	}
+
+	return tokens, tx.Commit()
+}
+
+// inserts a refresh token, and an access token unless accessTTL is 0, in a family
+func insertSessionTokens(ctx context.Context, tx *sql.Tx, userID int64, familyID uuid.UUID, accessTTL, refreshTTL time.Duration, ip, userAgent string) (*SessionTokens, error) {
+	var tokens SessionTokens
+	var err error
+
+	if accessTTL > 0 {
+		tokens.Access, err = generateToken(userID, accessTTL, ScopeAuthentication)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	tokens.Refresh, err = generateToken(userID, refreshTTL, ScopeRefresh)
+	if err != nil {
+		return nil, err
+	}
+
+	userAgent = CleanUserAgent(userAgent)
+
+	for _, token := range []*Token{tokens.Access, tokens.Refresh} {
+		if token == nil {
+			continue
+		}
+
+		token.FamilyID = familyID
+		token.IP = ip
+		token.UserAgent = userAgent
+
+		err = insertToken(ctx, tx, token)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &tokens, nil
+}
+
+// returns the user's active sessions, newest first
+// the id of a session is the id of the first token in its family so it stays
+// the same across refreshes. The session making the request is marked as current,
+// found by its database access token or, for signed access tokens, its family
+func (m *TokenModel) GetSessionsForUser(userID int64, currentPlaintext string, currentFamily uuid.UUID) ([]*Session, error) {
+	// ip and user agent come from the most recently issued token
+	stmt := `SELECT min(id), min(created_at), max(last_used_at),
+    max(expiry) FILTER (WHERE used_at IS NULL),
+    (array_agg(ip ORDER BY id DESC))[1],
+    (array_agg(user_agent ORDER BY id DESC))[1],
+    bool_or(hash = $3) OR family_id = $4
+  FROM tokens
+  WHERE user_id = $1 AND scope = ANY($2)
+  GROUP BY family_id
+  HAVING bool_or(expiry > NOW() AND used_at IS NULL)
+  ORDER BY min(created_at) DESC, min(id) DESC`
+
+	currentHash := tokenHash(currentPlaintext)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	scopes := []string{ScopeAuthentication, ScopeRefresh}
+
+	rows, err := m.DB.QueryContext(ctx, stmt, userID, pq.Array(scopes), currentHash, currentFamily)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+
+	for rows.Next() {
+		var s Session
+
+		err := rows.Scan(&s.ID, &s.CreatedAt, &s.LastUsedAt, &s.Expiry, &s.IP, &s.UserAgent, &s.Current)
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, &s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// revokes one of the user's sessions by id along with every token in its family
+// returns ErrRecordNotFound if the session doesn't exist or belongs to someone else
+func (m *TokenModel) DeleteSessionForUser(id, userID int64) error {
+	stmt := `DELETE FROM tokens
+  WHERE family_id = (
+    SELECT family_id FROM tokens
+    WHERE id = $1 AND user_id = $2 AND scope = ANY($3)
+  )`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	scopes := []string{ScopeAuthentication, ScopeRefresh}
+
+	result, err := m.DB.ExecContext(ctx, stmt, id, userID, pq.Array(scopes))
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// revokes the session a plaintext access token belongs to
+func (m *TokenModel) DeleteSession(tokenPlaintext string) error {
+	stmt := `DELETE FROM tokens
+  WHERE family_id = (
+    SELECT family_id FROM tokens
+    WHERE hash = $1 AND scope = $2
+  )`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, stmt, tokenHash(tokenPlaintext), ScopeAuthentication)
+	return err
+}
+
+// revokes a session by its family id
+func (m *TokenModel) DeleteSessionFamily(familyID uuid.UUID) error {
+	stmt := `DELETE FROM tokens
+  WHERE family_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, stmt, familyID)
+	return err
+}
+
+// revokes every session the user has, logging them out everywhere
+func (m *TokenModel) DeleteAllSessionsForUser(userID int64) error {
+	stmt := `DELETE FROM tokens
+  WHERE user_id = $1 AND scope = ANY($2)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	scopes := []string{ScopeAuthentication, ScopeRefresh}
+
+	_, err := m.DB.ExecContext(ctx, stmt, userID, pq.Array(scopes))
+	return err
+}
+
+// removes expired tokens
+// within a live session the first token is kept since its id is the session id
+func (m *TokenModel) DeleteExpired() (int64, error) {
+	stmt := `DELETE FROM tokens t
+  WHERE t.expiry < NOW()
+  AND (
+    t.scope <> ALL($1)
+    OR NOT EXISTS (SELECT 1 FROM tokens f WHERE f.family_id = t.family_id AND f.expiry > NOW())
+    OR t.id <> (SELECT min(f.id) FROM tokens f WHERE f.family_id = t.family_id)
+  )`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	scopes := []string{ScopeAuthentication, ScopeRefresh}
+
+	result, err := m.DB.ExecContext(ctx, stmt, pq.Array(scopes))
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// writes last used times for many sessions in a single statement
+// lastUsed maps plaintext tokens to when they were last seen
+func (m *TokenModel) UpdateLastUsed(lastUsed map[string]time.Time) error {
+	if len(lastUsed) == 0 {
+		return nil
+	}
+
+	stmt := `UPDATE tokens
+  SET last_used_at = to_timestamp(u.used_at)
+  FROM unnest($1::bytea[], $2::bigint[]) AS u(hash, used_at)
+  WHERE tokens.hash = u.hash
+  AND (tokens.last_used_at IS NULL OR tokens.last_used_at < to_timestamp(u.used_at))`
+
+	hashes := make([][]byte, 0, len(lastUsed))
+	usedAt := make([]int64, 0, len(lastUsed))
+
+	for plaintext, t := range lastUsed {
+		// tokens from a retired key can't match anything
+		hash := tokenHash(plaintext)
+		if hash == nil {
+			continue
+		}
+
+		hashes = append(hashes, hash)
+		usedAt = append(usedAt, t.Unix())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, stmt, pq.Array(hashes), pq.Array(usedAt))
+	return err
+}
@@ -0,0 +1,185 @@
+package data
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// tokens are stored as an HMAC of the plaintext keyed with a server-side
+// pepper, so a copy of the tokens table is no use for checking guessed tokens
+// without the keys as well
+//
+// plaintext formats, the version decides how the hash is calculated
+//
+//	v0 (legacy): <26 base32 chars>                 sha256(plaintext)
+//	v1:          v1_<key id>_<26 base32 chars>      hmac-sha256(key, plaintext)
+const (
+	tokenRandomLength = 26
+	tokenV1Prefix     = "v1_"
+)
+
+// key ids can't contain underscores since they separate the parts of a token
+var TokenKeyIDRX = regexp.MustCompile(`^[A-Za-z0-9-]{1,32}$`)
+
+// key id stored for legacy sha256 tokens
+const legacyTokenKeyID = ""
+
+// TokenKeys holds every pepper tokens can be checked with, new tokens use the
+// active one. Rotate by adding a new key and making it active, then retire the
+// old one with cmd/tokenkeys once the tokens issued with it aren't needed
+type TokenKeys struct {
+	active string
+	keys   map[string][]byte
+}
+
+// token key file format
+//
+//	{
+//	  "active": "2024-06",
+//	  "keys": [
+//	    {"kid": "2024-06", "key": "<base64 secret, at least 32 bytes>"},
+//	    {"kid": "2024-01", "key": "<base64 secret, at least 32 bytes>"}
+//	  ]
+//	}
+type tokenKeysFile struct {
+	Active string `json:"active"`
+	Keys   []struct {
+		Kid string `json:"kid"`
+		Key string `json:"key"`
+	} `json:"keys"`
+}
+
+// LoadTokenKeys reads and validates a token key file from disk
+func LoadTokenKeys(path string) (*TokenKeys, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file tokenKeysFile
+
+	err = json.Unmarshal(b, &file)
+	if err != nil {
+		return nil, fmt.Errorf("token keys: %w", err)
+	}
+
+	tk := &TokenKeys{active: file.Active, keys: make(map[string][]byte)}
+
+	for _, k := range file.Keys {
+		if !TokenKeyIDRX.MatchString(k.Kid) {
+			return nil, fmt.Errorf("token keys: kid %q must be 1 to 32 letters, digits or dashes", k.Kid)
+		}
+
+		if _, exists := tk.keys[k.Kid]; exists {
+			return nil, fmt.Errorf("token keys: duplicate kid %q", k.Kid)
+		}
+
+		raw, err := base64.StdEncoding.DecodeString(k.Key)
+		if err != nil {
+			return nil, fmt.Errorf("token keys: key %q is not valid base64", k.Kid)
+		}
+
+		if len(raw) < 32 {
+			return nil, fmt.Errorf("token keys: key %q must be at least 32 bytes", k.Kid)
+		}
+
+		tk.keys[k.Kid] = raw
+	}
+
+	if _, ok := tk.keys[tk.active]; !ok {
+		return nil, fmt.Errorf("token keys: active key %q not found", tk.active)
+	}
+
+	return tk, nil
+}
+
+// the key new tokens are issued with
+func (tk *TokenKeys) Active() string {
+	return tk.active
+}
+
+// every key id in the set, sorted
+func (tk *TokenKeys) IDs() []string {
+	ids := make([]string, 0, len(tk.keys))
+	for id := range tk.keys {
+		ids = append(ids, id)
+	}
+
+	slices.Sort(ids)
+
+	return ids
+}
+
+// returns a new random key, base64 encoded for a token key file
+func GenerateTokenKey() (string, error) {
+	key := make([]byte, 32)
+
+	_, err := rand.Read(key)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// tokens are issued and checked with these keys, nil issues legacy tokens
+var tokenKeys *TokenKeys
+
+// sets the keys tokens are hashed with, must be called before serving requests
+func SetTokenKeys(keys *TokenKeys) {
+	tokenKeys = keys
+}
+
+// splits a plaintext token into its key id and reports whether it's well formed
+func parseTokenPlaintext(plaintext string) (string, bool) {
+	rest, ok := strings.CutPrefix(plaintext, tokenV1Prefix)
+	if !ok {
+		return legacyTokenKeyID, len(plaintext) == tokenRandomLength
+	}
+
+	kid, random, ok := strings.Cut(rest, "_")
+
+	return kid, ok && TokenKeyIDRX.MatchString(kid) && len(random) == tokenRandomLength
+}
+
+// returns the hash a plaintext token is stored under, nil if it's malformed
+// or was issued with a key that's no longer in the set, which matches nothing
+func tokenHash(plaintext string) []byte {
+	kid, ok := parseTokenPlaintext(plaintext)
+	if !ok {
+		return nil
+	}
+
+	if kid == legacyTokenKeyID {
+		hash := sha256.Sum256([]byte(plaintext))
+		return hash[:]
+	}
+
+	if tokenKeys == nil {
+		return nil
+	}
+
+	key, ok := tokenKeys.keys[kid]
+	if !ok {
+		return nil
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(plaintext))
+
+	return mac.Sum(nil)
+}
+
+// whether s looks like a token in any of the supported formats
+func IsTokenPlaintext(s string) bool {
+	_, ok := parseTokenPlaintext(s)
+	return ok
+}
@@ -0,0 +1,208 @@
+package data
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"strings"
+	"testing"
+
+	"github.com/V4N1LLA-1CE/movie-db-api/internal/validator"
+)
+
+// 26 base32 characters, the random part of every token
+const testTokenRandom = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+// sets the token keys for the rest of the test, keys maps kid to key
+func setTestTokenKeys(t *testing.T, active string, keys map[string]string) {
+	t.Helper()
+
+	old := tokenKeys
+	t.Cleanup(func() { tokenKeys = old })
+
+	tk := &TokenKeys{active: active, keys: make(map[string][]byte)}
+	for kid, key := range keys {
+		tk.keys[kid] = []byte(key)
+	}
+
+	SetTokenKeys(tk)
+}
+
+func testHMAC(key, plaintext string) []byte {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(plaintext))
+	return mac.Sum(nil)
+}
+
+func TestParseTokenPlaintext(t *testing.T) {
+	tests := []struct {
+		name      string
+		plaintext string
+		wantKid   string
+		wantOK    bool
+	}{
+		{"legacy", testTokenRandom, legacyTokenKeyID, true},
+		{"legacy too short", testTokenRandom[:25], legacyTokenKeyID, false},
+		{"legacy too long", testTokenRandom + "A", legacyTokenKeyID, false},
+		{"empty", "", legacyTokenKeyID, false},
+		{"v1", "v1_2024-06_" + testTokenRandom, "2024-06", true},
+		{"v1 longest kid", "v1_" + strings.Repeat("k", 32) + "_" + testTokenRandom, strings.Repeat("k", 32), true},
+		{"v1 kid too long", "v1_" + strings.Repeat("k", 33) + "_" + testTokenRandom, "", false},
+		{"v1 empty kid", "v1__" + testTokenRandom, "", false},
+		{"v1 kid with bad characters", "v1_2024.06_" + testTokenRandom, "", false},
+		{"v1 missing separator", "v1_2024-06" + testTokenRandom, "", false},
+		{"v1 random too short", "v1_2024-06_" + testTokenRandom[:25], "", false},
+		{"v1 random too long", "v1_2024-06_" + testTokenRandom + "A", "", false},
+		{"v1 extra part", "v1_2024-06_" + testTokenRandom + "_x", "", false},
+		{"unknown version", "v2_2024-06_" + testTokenRandom, legacyTokenKeyID, false},
+		{"uppercase prefix", "V1_2024-06_" + testTokenRandom, legacyTokenKeyID, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kid, ok := parseTokenPlaintext(tt.plaintext)
+			if ok != tt.wantOK {
+				t.Fatalf("parseTokenPlaintext(%q) ok = %t, want %t", tt.plaintext, ok, tt.wantOK)
+			}
+
+			if ok && kid != tt.wantKid {
+				t.Errorf("parseTokenPlaintext(%q) kid = %q, want %q", tt.plaintext, kid, tt.wantKid)
+			}
+
+			if IsTokenPlaintext(tt.plaintext) != tt.wantOK {
+				t.Errorf("IsTokenPlaintext(%q) = %t, want %t", tt.plaintext, !tt.wantOK, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestTokenHash(t *testing.T) {
+	const (
+		activeKey  = "active-key-active-key-active-key"
+		rotatedKey = "rotated-key-rotated-key-rotated-"
+	)
+
+	setTestTokenKeys(t, "2024-06", map[string]string{"2024-06": activeKey, "2024-01": rotatedKey})
+
+	legacy := sha256.Sum256([]byte(testTokenRandom))
+
+	tests := []struct {
+		name      string
+		plaintext string
+		want      []byte
+	}{
+		{"legacy is plain sha256", testTokenRandom, legacy[:]},
+		{"active key", "v1_2024-06_" + testTokenRandom, testHMAC(activeKey, "v1_2024-06_"+testTokenRandom)},
+		{"older key still in the set", "v1_2024-01_" + testTokenRandom, testHMAC(rotatedKey, "v1_2024-01_"+testTokenRandom)},
+		{"retired key", "v1_2023-01_" + testTokenRandom, nil},
+		{"malformed v1", "v1_2024-06_" + testTokenRandom[:25], nil},
+		{"malformed legacy", testTokenRandom[:25], nil},
+		{"empty", "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tokenHash(tt.plaintext)
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("tokenHash(%q) = %x, want %x", tt.plaintext, got, tt.want)
+			}
+		})
+	}
+
+	// the same random part under different keys must never share a hash
+	if bytes.Equal(tokenHash("v1_2024-06_"+testTokenRandom), tokenHash("v1_2024-01_"+testTokenRandom)) {
+		t.Error("tokens with different kids hashed the same")
+	}
+}
+
+func TestTokenHashWithoutKeys(t *testing.T) {
+	old := tokenKeys
+	t.Cleanup(func() { tokenKeys = old })
+
+	SetTokenKeys(nil)
+
+	if got := tokenHash("v1_2024-06_" + testTokenRandom); got != nil {
+		t.Errorf("v1 token hashed to %x without any keys, want nil", got)
+	}
+
+	if got := tokenHash(testTokenRandom); got == nil {
+		t.Error("legacy token didn't hash without any keys")
+	}
+}
+
+func TestNewTokenPlaintext(t *testing.T) {
+	t.Run("with keys", func(t *testing.T) {
+		setTestTokenKeys(t, "2024-06", map[string]string{"2024-06": "active-key-active-key-active-key"})
+
+		plaintext, kid, err := newTokenPlaintext()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if kid != "2024-06" || !strings.HasPrefix(plaintext, "v1_2024-06_") {
+			t.Fatalf("newTokenPlaintext() = %q, %q, want a v1 token for the active key", plaintext, kid)
+		}
+
+		if tokenHash(plaintext) == nil {
+			t.Error("a newly issued token doesn't hash")
+		}
+
+		// rotating away from the key leaves the token checkable until it's retired
+		setTestTokenKeys(t, "2024-12", map[string]string{"2024-06": "active-key-active-key-active-key", "2024-12": "newer-key-newer-key-newer-key-ne"})
+
+		if tokenHash(plaintext) == nil {
+			t.Error("token stopped hashing once its key was no longer active")
+		}
+
+		setTestTokenKeys(t, "2024-12", map[string]string{"2024-12": "newer-key-newer-key-newer-key-ne"})
+
+		if got := tokenHash(plaintext); got != nil {
+			t.Errorf("token hashed to %x after its key was retired, want nil", got)
+		}
+	})
+
+	t.Run("without keys", func(t *testing.T) {
+		old := tokenKeys
+		t.Cleanup(func() { tokenKeys = old })
+
+		SetTokenKeys(nil)
+
+		plaintext, kid, err := newTokenPlaintext()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if kid != legacyTokenKeyID || len(plaintext) != tokenRandomLength {
+			t.Errorf("newTokenPlaintext() = %q, %q, want a legacy token", plaintext, kid)
+		}
+	})
+}
+
+func TestValidateTokenPlaintext(t *testing.T) {
+	tests := []struct {
+		name      string
+		plaintext string
+		wantError string
+	}{
+		{"legacy", testTokenRandom, ""},
+		{"legacy too short", testTokenRandom[:25], "must be 26 bytes long"},
+		{"legacy too long", testTokenRandom + "A", "must be 26 bytes long"},
+		{"empty", "", "must be provided"},
+		{"v1", "v1_2024-06_" + testTokenRandom, ""},
+		{"v1 random too short", "v1_2024-06_" + testTokenRandom[:25], "is not a valid token"},
+		{"v1 random too long", "v1_2024-06_" + testTokenRandom + "A", "is not a valid token"},
+		{"v1 bad kid", "v1_2024.06_" + testTokenRandom, "is not a valid token"},
+		{"v1 prefix only", "v1_", "is not a valid token"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := validator.New()
+			ValidateTokenPlaintext(v, tt.plaintext)
+
+			if got := v.Errors["token"]; got != tt.wantError {
+				t.Errorf("token error = %q, want %q", got, tt.wantError)
+			}
+		})
+	}
+}
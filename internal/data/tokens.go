@@ -3,26 +3,40 @@ package data
 import (
 	"context"
 	"crypto/rand"
-	"crypto/sha256"
 	"database/sql"
 	"encoding/base32"
+	"errors"
+	"strings"
 	"time"
 
 	"github.com/V4N1LLA-1CE/movie-db-api/internal/validator"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 // define constants for token scope
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
+	ScopePasswordReset  = "password-reset"
+	ScopeEmailChange    = "email-change"
+	ScopeAccountRestore = "account-restore"
+	ScopeRefresh        = "refresh"
+	ScopeMFA            = "mfa"
+	ScopeAccountUnlock  = "account-unlock"
+	ScopeMagicLink      = "magic-link"
 )
 
 type Token struct {
 	Plaintext string    `json:"token"`
 	Hash      []byte    `json:"-"`
+	KeyID     string    `json:"-"` // the pepper the hash was made with, empty for legacy sha256 tokens
 	UserID    int64     `json:"-"`
 	Expiry    time.Time `json:"expiry"`
 	Scope     string    `json:"-"`
+	IP        string    `json:"-"`
+	UserAgent string    `json:"-"`
+	FamilyID  uuid.UUID `json:"-"`
 }
 
 func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
@@ -32,8 +46,24 @@ func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error
 		UserID: userID,
 		Expiry: time.Now().Add(ttl),
 		Scope:  scope,
+		// tokens start their own family unless they're issued as part of a session
+		FamilyID: uuid.New(),
 	}
 
+	plaintext, keyID, err := newTokenPlaintext()
+	if err != nil {
+		return nil, err
+	}
+
+	token.Plaintext = plaintext
+	token.KeyID = keyID
+	token.Hash = tokenHash(token.Plaintext)
+
+	return token, nil
+}
+
+// random plaintext for tokens and invitations, along with the key it's hashed with
+func newTokenPlaintext() (string, string, error) {
 	// initialise empty byte slice to store hash
 	randomBytes := make([]byte, 16)
 
@@ -41,25 +71,32 @@ func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error
 	// this random byte will be encoded into plaintext and hashed into a hash
 	_, err := rand.Read(randomBytes)
 	if err != nil {
-		return nil, err
+		return "", "", err
 	}
 
 	// encode byte slice to base32-encoded string and assign to token plaintext
 	// whilst remove the padding character '=' to make token cleaner and cause less issues along the line (i.e. URLs)
-	token.Plaintext = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	plaintext := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
 
-	// generate sha256 hash of plaintext
-	// Sum256() returns fixed-sized array [32]byte
-	// hash[:] converts the hash array into slice []byte to match token hash type
-	hash := sha256.Sum256([]byte(token.Plaintext))
-	token.Hash = hash[:]
+	// with a pepper configured the token says which key it was hashed with so
+	// it can still be checked after the active key is rotated
+	if tokenKeys == nil {
+		return plaintext, legacyTokenKeyID, nil
+	}
 
-	return token, nil
+	return tokenV1Prefix + tokenKeys.Active() + "_" + plaintext, tokenKeys.Active(), nil
 }
 
 func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
 	v.Check(tokenPlaintext != "", "token", "must be provided")
-	v.Check(len(tokenPlaintext) == 26, "token", "must be 26 bytes long")
+
+	// v1 tokens carry the version and key id in front of the random part
+	if strings.HasPrefix(tokenPlaintext, tokenV1Prefix) {
+		v.Check(IsTokenPlaintext(tokenPlaintext), "token", "is not a valid token")
+		return
+	}
+
+	v.Check(len(tokenPlaintext) == tokenRandomLength, "token", "must be 26 bytes long")
 }
 
 type TokenModel struct {
@@ -77,15 +114,25 @@ func (m *TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token,
 }
 
 func (m *TokenModel) Insert(token *Token) error {
-	stmt := `INSERT INTO tokens (hash, user_id, expiry, scope)
-  VALUES ($1, $2, $3, $4)`
-
-	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope}
-
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := m.DB.ExecContext(ctx, stmt, args...)
+	return insertToken(ctx, m.DB, token)
+}
+
+// implemented by both *sql.DB and *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// shared by Insert and the session transactions
+func insertToken(ctx context.Context, db execer, token *Token) error {
+	stmt := `INSERT INTO tokens (hash, key_id, user_id, expiry, scope, ip, user_agent, family_id)
+  VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+
+	args := []any{token.Hash, token.KeyID, token.UserID, token.Expiry, token.Scope, token.IP, token.UserAgent, token.FamilyID}
+
+	_, err := db.ExecContext(ctx, stmt, args...)
 	return err
 }
 
@@ -101,3 +148,108 @@ func (m *TokenModel) DeleteAllForUser(scope string, userID int64) error {
 	_, err := m.DB.ExecContext(ctx, stmt, args...)
 	return err
 }
+
+// deletes a token and returns the id of the user it belonged to, so it can only
+// ever be used once even if it's sent twice at the same time
+// returns ErrRecordNotFound if the token doesn't exist or has expired
+func (m *TokenModel) Consume(scope, tokenPlaintext string) (int64, error) {
+	stmt := `DELETE FROM tokens
+  WHERE hash = $1 AND scope = $2 AND expiry > $3
+  RETURNING user_id`
+
+	args := []any{tokenHash(tokenPlaintext), scope, time.Now()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var userID int64
+
+	err := m.DB.QueryRowContext(ctx, stmt, args...).Scan(&userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	return userID, nil
+}
+
+// removes every token for a user regardless of scope
+func (m *TokenModel) DeleteAllScopesForUser(userID int64) error {
+	stmt := `DELETE FROM tokens
+  WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, stmt, userID)
+	return err
+}
+
+// number of tokens and invitations hashed with each key, legacy sha256 ones
+// are counted under an empty key id
+func (m *TokenModel) CountByKey() (map[string]int64, error) {
+	stmt := `SELECT key_id, count(*)
+  FROM (
+    SELECT key_id FROM tokens
+    UNION ALL
+    SELECT key_id FROM invitations
+  ) AS hashed
+  GROUP BY key_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, stmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int64)
+
+	for rows.Next() {
+		var keyID string
+		var count int64
+
+		err := rows.Scan(&keyID, &count)
+		if err != nil {
+			return nil, err
+		}
+
+		counts[keyID] = count
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
+
+// removes every token and invitation hashed with a key that isn't in keep,
+// including legacy sha256 ones. They can't be checked once their key is gone
+// anyway. Returns how many were deleted altogether
+func (m *TokenModel) DeleteForRetiredKeys(keep []string) (int64, error) {
+	stmt := `WITH deleted_tokens AS (
+    DELETE FROM tokens WHERE key_id <> ALL($1) RETURNING 1
+  ), deleted_invitations AS (
+    DELETE FROM invitations WHERE key_id <> ALL($1) RETURNING 1
+  )
+  SELECT (SELECT count(*) FROM deleted_tokens) + (SELECT count(*) FROM deleted_invitations)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var deleted int64
+
+	err := m.DB.QueryRowContext(ctx, stmt, pq.Array(keep)).Scan(&deleted)
+	if err != nil {
+		return 0, err
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,245 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/V4N1LLA-1CE/movie-db-api/internal/validator"
+)
+
+const recoveryCodeCount = 10
+
+var (
+	ErrTOTPAlreadyEnabled = errors.New("totp already enabled")
+)
+
+// TOTP is a user's two-factor authentication enrolment
+type TOTP struct {
+	UserID       int64
+	Secret       []byte
+	ConfirmedAt  *time.Time
+	LastUsedStep int64
+}
+
+// enrolment only counts once it has been confirmed with a code
+func (t *TOTP) Enabled() bool {
+	return t != nil && t.ConfirmedAt != nil
+}
+
+func ValidateTOTPCode(v *validator.Validator, code string) {
+	v.Check(code != "", "code", "must be provided")
+	v.Check(len(code) <= 20, "code", "must not be more than 20 characters long")
+}
+
+// recovery codes look like xxxxx-xxxxx, the dash is optional when they're entered
+func generateRecoveryCodes() ([]string, [][]byte, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([][]byte, recoveryCodeCount)
+
+	for i := range codes {
+		randomBytes := make([]byte, 7)
+
+		_, err := rand.Read(randomBytes)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		encoded := lowerBase32.EncodeToString(randomBytes)[:10]
+		codes[i] = encoded[:5] + "-" + encoded[5:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+
+	return codes, hashes, nil
+}
+
+func hashRecoveryCode(code string) []byte {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	hash := sha256.Sum256([]byte(code))
+	return hash[:]
+}
+
+type TOTPModel struct {
+	DB *sql.DB
+}
+
+// returns the user's enrolment, nil with no error if they haven't started one
+func (m *TOTPModel) GetForUser(userID int64) (*TOTP, error) {
+	stmt := `SELECT user_id, secret, confirmed_at, last_used_step
+  FROM user_totp
+  WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var t TOTP
+
+	err := m.DB.QueryRowContext(ctx, stmt, userID).Scan(&t.UserID, &t.Secret, &t.ConfirmedAt, &t.LastUsedStep)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil
+		default:
+			return nil, err
+		}
+	}
+
+	return &t, nil
+}
+
+// starts (or restarts) an enrolment with a new secret
+// returns ErrTOTPAlreadyEnabled if the user already has a confirmed one
+func (m *TOTPModel) Begin(userID int64, secret []byte) error {
+	stmt := `INSERT INTO user_totp (user_id, secret)
+  VALUES ($1, $2)
+  ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, last_used_step = 0
+  WHERE user_totp.confirmed_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, stmt, userID, secret)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return ErrTOTPAlreadyEnabled
+	}
+
+	return nil
+}
+
+// confirms an enrolment after a valid code for step, returning a fresh set of
+// recovery codes. The plaintext codes are only ever available here
+func (m *TOTPModel) Confirm(userID int64, step int64) ([]string, error) {
+	confirmStmt := `UPDATE user_totp
+  SET confirmed_at = NOW(), last_used_step = $2
+  WHERE user_id = $1 AND confirmed_at IS NULL`
+
+	deleteStmt := `DELETE FROM recovery_codes
+  WHERE user_id = $1`
+
+	insertStmt := `INSERT INTO recovery_codes (user_id, hash)
+  VALUES ($1, $2)`
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, confirmStmt, userID, step)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if rows == 0 {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+
+	_, err = tx.ExecContext(ctx, deleteStmt, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, hash := range hashes {
+		_, err = tx.ExecContext(ctx, insertStmt, userID, hash)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return codes, tx.Commit()
+}
+
+// records that a code for step was used, reports false if it (or a later
+// one) was already used so the same code can't be replayed
+func (m *TOTPModel) UseStep(userID int64, step int64) (bool, error) {
+	stmt := `UPDATE user_totp
+  SET last_used_step = $2
+  WHERE user_id = $1 AND confirmed_at IS NOT NULL AND last_used_step < $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, stmt, userID, step)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rows > 0, nil
+}
+
+// marks a recovery code as used, reports false if it doesn't exist or was used already
+func (m *TOTPModel) UseRecoveryCode(userID int64, code string) (bool, error) {
+	stmt := `UPDATE recovery_codes
+  SET used_at = NOW()
+  WHERE user_id = $1 AND hash = $2 AND used_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, stmt, userID, hashRecoveryCode(code))
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rows > 0, nil
+}
+
+// turns two-factor authentication off and removes the user's recovery codes
+func (m *TOTPModel) Delete(userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM user_totp WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
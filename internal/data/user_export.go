@@ -0,0 +1,103 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// tables that reference users without belonging to them, left out of the
+// export. An invitation points at the admin who sent it but holds someone
+// else's email and permissions
+var exportExcludedTables = []string{"invitations"}
+
+// GetOwnedRecords returns every row that references the user, keyed by table name
+// tables are found through foreign keys to users rather than a hardcoded list so
+// new user-owned tables end up in the export without touching this code
+// bytea columns (password and token hashes) are stripped from the output
+func (m *UserModel) GetOwnedRecords(userID int64) (map[string]json.RawMessage, error) {
+	// one row per table, with every column that points at users and every
+	// bytea column that should be left out
+	catalogStmt := `SELECT c.conrelid::regclass::text,
+    array_agg(DISTINCT quote_ident(a.attname)),
+    COALESCE((
+      SELECT array_agg(b.attname::text)
+      FROM pg_attribute b
+      WHERE b.attrelid = c.conrelid
+      AND b.atttypid = 'bytea'::regtype
+      AND b.attnum > 0
+      AND NOT b.attisdropped
+    ), '{}')
+  FROM pg_constraint c
+  INNER JOIN pg_attribute a ON a.attrelid = c.conrelid AND a.attnum = c.conkey[1]
+  WHERE c.contype = 'f'
+  AND c.confrelid = 'users'::regclass
+  AND array_length(c.conkey, 1) = 1
+  AND c.conrelid::regclass::text <> ALL($1)
+  GROUP BY c.conrelid
+  ORDER BY 1`
+
+	// the whole export shares one deadline since it runs a query per table
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	type ownedTable struct {
+		name    string
+		columns []string
+		hidden  []string
+	}
+
+	rows, err := m.DB.QueryContext(ctx, catalogStmt, pq.Array(exportExcludedTables))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tables []ownedTable
+
+	for rows.Next() {
+		var t ownedTable
+
+		err := rows.Scan(&t.name, pq.Array(&t.columns), pq.Array(&t.hidden))
+		if err != nil {
+			return nil, err
+		}
+
+		tables = append(tables, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	records := make(map[string]json.RawMessage, len(tables))
+
+	for _, t := range tables {
+		// table and column names come from the catalog already quoted, never from the client
+		where := ""
+		for i, col := range t.columns {
+			if i > 0 {
+				where += " OR "
+			}
+			where += fmt.Sprintf("t.%s = $1", col)
+		}
+
+		stmt := fmt.Sprintf(`SELECT COALESCE(json_agg(to_jsonb(t) - $2::text[]), '[]')
+  FROM %s t
+  WHERE %s`, t.name, where)
+
+		var data []byte
+
+		err := m.DB.QueryRowContext(ctx, stmt, userID, pq.Array(t.hidden)).Scan(&data)
+		if err != nil {
+			return nil, err
+		}
+
+		records[t.name] = data
+	}
+
+	return records, nil
+}
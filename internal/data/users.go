@@ -2,27 +2,29 @@ package data
 
 import (
 	"context"
-	"crypto/sha256"
 	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/V4N1LLA-1CE/movie-db-api/internal/validator"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgconn"
-	"golang.org/x/crypto/bcrypt"
 )
 
 var AnonUser = &User{}
 
 type User struct {
-	ID        int64     `json:"id"`
-	Name      string    `json:"name"`
-	Email     string    `json:"email"`
-	Password  password  `json:"-"`
-	Activated bool      `json:"activated"`
-	CreatedAt time.Time `json:"created_at"`
-	Version   uuid.UUID `json:"-"`
+	ID                  int64      `json:"id"`
+	Name                string     `json:"name"`
+	Email               string     `json:"email"`
+	PendingEmail        string     `json:"pending_email,omitempty"` // waiting for confirmation before it replaces email
+	Password            password   `json:"-"`
+	Activated           bool       `json:"activated"`
+	CreatedAt           time.Time  `json:"created_at"`
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at,omitempty"` // nil unless the user asked to delete their account
+	DeactivatedAt       *time.Time `json:"deactivated_at,omitempty"`        // set when an admin locks the user out
+	Version             uuid.UUID  `json:"-"`
 }
 
 // check if user is anonymous user
@@ -30,38 +32,9 @@ func (u *User) IsAnon() bool {
 	return u == AnonUser
 }
 
-// use pointer to distinguish between nil and "" when there's no password
-type password struct {
-	plaintext *string
-	hash      []byte
-}
-
-func (p *password) Set(plaintext string) error {
-	// generate bcrypt hash
-	// use 12 as cost param
-	hash, err := bcrypt.GenerateFromPassword([]byte(plaintext), 12)
-	if err != nil {
-		return err
-	}
-
-	p.plaintext = &plaintext
-	p.hash = hash
-
-	return nil
-}
-
-func (p *password) Matches(plaintext string) (bool, error) {
-	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plaintext))
-	if err != nil {
-		switch {
-		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
-			return false, nil
-		default:
-			return false, err
-		}
-	}
-
-	return true, nil
+// deactivated users can't log in or use any of their tokens or api keys
+func (u *User) IsDeactivated() bool {
+	return u.DeactivatedAt != nil
 }
 
 func ValidateEmail(v *validator.Validator, email string) {
@@ -69,12 +42,6 @@ func ValidateEmail(v *validator.Validator, email string) {
 	v.Check(validator.Matches(email, validator.EmailRegex), "email", "must be a valid email address")
 }
 
-func ValidatePasswordPlaintext(v *validator.Validator, password string) {
-	v.Check(password != "", "password", "must be provided")
-	v.Check(len(password) >= 8, "password", "must be at least 8 characters long")
-	v.Check(len(password) <= 72, "password", "must not exceed 72 characters long")
-}
-
 func ValidateUser(v *validator.Validator, user *User) {
 	v.Check(user.Name != "", "name", "must be provided")
 	v.Check(len(user.Name) <= 500, "name", "must not exceed 500 characters long")
@@ -83,7 +50,7 @@ func ValidateUser(v *validator.Validator, user *User) {
 
 	// if plaintext pass is not nil, validate the plaintext
 	if user.Password.plaintext != nil {
-		ValidatePasswordPlaintext(v, *user.Password.plaintext)
+		ValidateNewPassword(v, *user.Password.plaintext, user)
 	}
 
 	if user.Password.hash == nil {
@@ -100,17 +67,27 @@ type UserModel struct {
 }
 
 func (m *UserModel) Insert(user *User) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return insertUser(ctx, m.DB, user)
+}
+
+// implemented by both *sql.DB and *sql.Tx
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// shared by Insert and InvitationModel.Accept
+func insertUser(ctx context.Context, db queryRower, user *User) error {
 	stmt := `INSERT INTO users (name, email, password_hash, activated)
   VALUES ($1, $2, $3, $4)
   RETURNING id, created_at, version`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
 	args := []any{user.Name, user.Email, user.Password.hash, user.Activated}
 
 	// check and return duplicate email error, otherwise return error
-	err := m.DB.QueryRowContext(ctx, stmt, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
+	err := db.QueryRowContext(ctx, stmt, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
 	if err != nil {
 		var pgErr *pgconn.PgError
 		switch {
@@ -126,7 +103,7 @@ func (m *UserModel) Insert(user *User) error {
 }
 
 func (m *UserModel) GetByEmail(email string) (*User, error) {
-	stmt := `SELECT id, created_at, name, email, password_hash, activated, version
+	stmt := `SELECT id, created_at, name, email, pending_email, password_hash, activated, deletion_scheduled_at, deactivated_at, version
   FROM users
   WHERE email = $1`
 
@@ -140,8 +117,46 @@ func (m *UserModel) GetByEmail(email string) (*User, error) {
 		&user.CreatedAt,
 		&user.Name,
 		&user.Email,
+		&user.PendingEmail,
+		&user.Password.hash,
+		&user.Activated,
+		&user.DeletionScheduledAt,
+		&user.DeactivatedAt,
+		&user.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+func (m *UserModel) Get(id int64) (*User, error) {
+	stmt := `SELECT id, created_at, name, email, pending_email, password_hash, activated, deletion_scheduled_at, deactivated_at, version
+  FROM users
+  WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var user User
+
+	err := m.DB.QueryRowContext(ctx, stmt, id).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.PendingEmail,
 		&user.Password.hash,
 		&user.Activated,
+		&user.DeletionScheduledAt,
+		&user.DeactivatedAt,
 		&user.Version,
 	)
 
@@ -159,8 +174,8 @@ func (m *UserModel) GetByEmail(email string) (*User, error) {
 
 func (m *UserModel) Update(user *User) error {
 	stmt := `UPDATE users
-  SET name = $1, email = $2, password_hash = $3, activated = $4, version = uuid_generate_v4()
-  WHERE id = $5 AND version = $6
+  SET name = $1, email = $2, pending_email = $3, password_hash = $4, activated = $5, deletion_scheduled_at = $6, deactivated_at = $7, version = uuid_generate_v4()
+  WHERE id = $8 AND version = $9
   RETURNING version`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -169,8 +184,11 @@ func (m *UserModel) Update(user *User) error {
 	args := []any{
 		user.Name,
 		user.Email,
+		user.PendingEmail,
 		user.Password.hash,
 		user.Activated,
+		user.DeletionScheduledAt,
+		user.DeactivatedAt,
 		user.ID,
 		user.Version,
 	}
@@ -191,12 +209,27 @@ func (m *UserModel) Update(user *User) error {
 	return nil
 }
 
+// swaps the password hash for one of the same password under the current
+// policy. Nothing else about the user changes so version is left alone, and
+// it's skipped if the password was changed in the meantime
+func (m *UserModel) UpdatePasswordHash(user *User, oldHash []byte) error {
+	stmt := `UPDATE users
+  SET password_hash = $1
+  WHERE id = $2 AND password_hash = $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, stmt, user.Password.hash, user.ID, oldHash)
+	return err
+}
+
 func (m *UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
 	// calc the hash from plaintext
-	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+	hash := tokenHash(tokenPlaintext)
 
 	stmt := `
-      SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version
+      SELECT users.id, users.created_at, users.name, users.email, users.pending_email, users.password_hash, users.activated, users.deletion_scheduled_at, users.deactivated_at, users.version
       FROM users
       INNER JOIN tokens
       ON users.id = tokens.user_id
@@ -204,7 +237,7 @@ func (m *UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error
       AND tokens.scope = $2
       AND tokens.expiry > $3`
 
-	args := []any{tokenHash[:], tokenScope, time.Now()}
+	args := []any{hash, tokenScope, time.Now()}
 
 	var user User
 
@@ -217,8 +250,11 @@ func (m *UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error
 		&user.CreatedAt,
 		&user.Name,
 		&user.Email,
+		&user.PendingEmail,
 		&user.Password.hash,
 		&user.Activated,
+		&user.DeletionScheduledAt,
+		&user.DeactivatedAt,
 		&user.Version,
 	)
 	if err != nil {
@@ -233,3 +269,91 @@ func (m *UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error
 	// return matching user
 	return &user, nil
 }
+
+// what the admin user list can be narrowed down by, nil fields match everything
+type UserSearch struct {
+	Email         string // matches anywhere in the address
+	Activated     *bool
+	Deactivated   *bool
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+}
+
+func (m *UserModel) GetAll(search UserSearch, filters Filters) ([]*User, Metadata, error) {
+	stmt := fmt.Sprintf(`SELECT count(*) OVER(), id, created_at, name, email, pending_email, activated, deletion_scheduled_at, deactivated_at
+  FROM users
+  WHERE (email ILIKE '%%' || $1 || '%%' ESCAPE '\' OR $1 = '')
+  AND (activated = $2 OR $2::boolean IS NULL)
+  AND ((deactivated_at IS NOT NULL) = $3 OR $3::boolean IS NULL)
+  AND (created_at >= $4 OR $4::timestamptz IS NULL)
+  AND (created_at < $5 OR $5::timestamptz IS NULL)
+  ORDER BY %s %s, id ASC
+  LIMIT $6 OFFSET $7`, filters.sortColumn(), filters.sortDirection())
+
+	// the search is matched literally, % and _ aren't wildcards
+	args := []any{
+		likeEscaper.Replace(search.Email),
+		search.Activated,
+		search.Deactivated,
+		search.CreatedAfter,
+		search.CreatedBefore,
+		filters.limit(),
+		filters.offset(),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	users := []*User{}
+
+	for rows.Next() {
+		var user User
+
+		err := rows.Scan(
+			&totalRecords,
+			&user.ID,
+			&user.CreatedAt,
+			&user.Name,
+			&user.Email,
+			&user.PendingEmail,
+			&user.Activated,
+			&user.DeletionScheduledAt,
+			&user.DeactivatedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		users = append(users, &user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return users, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// hard deletes every user whose grace period has run out
+// tokens, permissions and anything else referencing users goes with them through ON DELETE CASCADE
+func (m *UserModel) DeleteScheduled() (int64, error) {
+	stmt := `DELETE FROM users
+  WHERE deletion_scheduled_at <= NOW()`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, stmt)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,114 @@
+package disposable
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+// lists of disposable email domains, one domain per line
+//
+//	# comments start with a hash
+//	mailinator.com
+//	yopmail.com
+//
+// a domain on the list also covers its subdomains
+
+//go:embed domains.txt
+var common string
+
+// List is a set of disposable email domains
+type List struct {
+	domains []string // sorted and lowercase so lookups can binary search
+}
+
+// Common returns the list of disposable domains shipped with the binary
+func Common() *List {
+	l, err := Read(strings.NewReader(common))
+	if err != nil {
+		panic("disposable: embedded list is invalid: " + err.Error())
+	}
+
+	return l
+}
+
+// Load reads a list from a local file
+func Load(path string) (*List, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Read(f)
+}
+
+// Read reads a list in the format above
+func Read(r io.Reader) (*List, error) {
+	var domains []string
+
+	scanner := bufio.NewScanner(r)
+	line := 0
+
+	for scanner.Scan() {
+		line++
+
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		if strings.ContainsAny(text, " \t@") || !strings.Contains(text, ".") {
+			return nil, fmt.Errorf("disposable: line %d: expected a domain", line)
+		}
+
+		domains = append(domains, text)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("disposable: %w", err)
+	}
+
+	return New(domains...), nil
+}
+
+// New returns a list of domains
+func New(domains ...string) *List {
+	lower := make([]string, 0, len(domains))
+	for _, d := range domains {
+		lower = append(lower, strings.ToLower(strings.TrimSuffix(d, ".")))
+	}
+
+	slices.Sort(lower)
+
+	return &List{domains: slices.Compact(lower)}
+}
+
+// Merge returns a list with the domains from both lists
+func (l *List) Merge(other *List) *List {
+	return New(append(slices.Clone(l.domains), other.domains...)...)
+}
+
+// Len returns the number of domains in the list
+func (l *List) Len() int {
+	return len(l.domains)
+}
+
+// Contains reports whether the domain or one of its parents is on the list
+func (l *List) Contains(domain string) bool {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+
+	for domain != "" {
+		if _, found := slices.BinarySearch(l.domains, domain); found {
+			return true
+		}
+
+		_, domain, _ = strings.Cut(domain, ".")
+	}
+
+	return false
+}
@@ -0,0 +1,242 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// minimal JWT implementation for signed access tokens
+// only EdDSA (Ed25519) and HS256 are supported, the algorithm always comes
+// from the keyset entry picked by "kid" and never from the token itself
+
+const (
+	AlgEdDSA = "EdDSA"
+	AlgHS256 = "HS256"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrExpiredToken = errors.New("token has expired")
+	ErrUnknownKey   = errors.New("token signed with unknown key")
+)
+
+var encoding = base64.RawURLEncoding
+
+// Claims carried by a signed access token
+type Claims struct {
+	Subject     int64    `json:"sub"`
+	SessionID   string   `json:"sid"`
+	Activated   bool     `json:"act"`
+	Permissions []string `json:"perms"`
+	MFA         bool     `json:"mfa,omitempty"` // user has two-factor authentication enabled
+	IssuedAt    int64    `json:"iat"`
+	ExpiresAt   int64    `json:"exp"`
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+	Kid string `json:"kid"`
+}
+
+type key struct {
+	alg     string
+	secret  []byte
+	private ed25519.PrivateKey
+	public  ed25519.PublicKey
+}
+
+// Keyset holds every key tokens can be verified with, new tokens are signed
+// with the active one. Rotate by adding a new key, making it active, and
+// removing the old one once tokens signed with it have expired
+type Keyset struct {
+	active string
+	keys   map[string]*key
+}
+
+// keyset file format
+//
+//	{
+//	  "active": "2024-06",
+//	  "keys": [
+//	    {"kid": "2024-06", "alg": "EdDSA", "key": "<base64 ed25519 seed or private key>"},
+//	    {"kid": "2024-01", "alg": "HS256", "key": "<base64 secret, at least 32 bytes>"}
+//	  ]
+//	}
+type keysetFile struct {
+	Active string `json:"active"`
+	Keys   []struct {
+		Kid string `json:"kid"`
+		Alg string `json:"alg"`
+		Key string `json:"key"`
+	} `json:"keys"`
+}
+
+// LoadKeyset reads and validates a keyset file from disk
+func LoadKeyset(path string) (*Keyset, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file keysetFile
+
+	err = json.Unmarshal(b, &file)
+	if err != nil {
+		return nil, fmt.Errorf("keyset: %w", err)
+	}
+
+	ks := &Keyset{active: file.Active, keys: make(map[string]*key)}
+
+	for _, k := range file.Keys {
+		if k.Kid == "" {
+			return nil, errors.New("keyset: every key needs a kid")
+		}
+
+		if _, exists := ks.keys[k.Kid]; exists {
+			return nil, fmt.Errorf("keyset: duplicate kid %q", k.Kid)
+		}
+
+		raw, err := base64.StdEncoding.DecodeString(k.Key)
+		if err != nil {
+			return nil, fmt.Errorf("keyset: key %q is not valid base64", k.Kid)
+		}
+
+		switch k.Alg {
+		case AlgEdDSA:
+			var private ed25519.PrivateKey
+
+			switch len(raw) {
+			case ed25519.SeedSize:
+				private = ed25519.NewKeyFromSeed(raw)
+			case ed25519.PrivateKeySize:
+				private = ed25519.PrivateKey(raw)
+			default:
+				return nil, fmt.Errorf("keyset: key %q must be a %d byte seed or %d byte private key", k.Kid, ed25519.SeedSize, ed25519.PrivateKeySize)
+			}
+
+			ks.keys[k.Kid] = &key{alg: AlgEdDSA, private: private, public: private.Public().(ed25519.PublicKey)}
+
+		case AlgHS256:
+			if len(raw) < 32 {
+				return nil, fmt.Errorf("keyset: key %q must be at least 32 bytes", k.Kid)
+			}
+
+			ks.keys[k.Kid] = &key{alg: AlgHS256, secret: raw}
+
+		default:
+			return nil, fmt.Errorf("keyset: key %q has unsupported alg %q", k.Kid, k.Alg)
+		}
+	}
+
+	if _, ok := ks.keys[ks.active]; !ok {
+		return nil, fmt.Errorf("keyset: active key %q not found", ks.active)
+	}
+
+	return ks, nil
+}
+
+// Sign encodes claims into a token signed with the active key
+func (ks *Keyset) Sign(claims Claims) (string, error) {
+	k := ks.keys[ks.active]
+
+	h, err := json.Marshal(header{Alg: k.alg, Typ: "JWT", Kid: ks.active})
+	if err != nil {
+		return "", err
+	}
+
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := encoding.EncodeToString(h) + "." + encoding.EncodeToString(c)
+
+	return signingInput + "." + encoding.EncodeToString(k.sign([]byte(signingInput))), nil
+}
+
+// Verify checks the signature and expiry of a token and returns its claims
+func (ks *Keyset) Verify(token string, now time.Time) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	hb, err := encoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	var h header
+
+	err = json.Unmarshal(hb, &h)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	k, ok := ks.keys[h.Kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	// refuse anything that doesn't match the key, i.e. "none" or alg confusion
+	if h.Alg != k.alg {
+		return nil, ErrInvalidToken
+	}
+
+	signature, err := encoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if !k.verify([]byte(parts[0]+"."+parts[1]), signature) {
+		return nil, ErrInvalidToken
+	}
+
+	cb, err := encoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	var claims Claims
+
+	err = json.Unmarshal(cb, &claims)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if now.Unix() >= claims.ExpiresAt {
+		return nil, ErrExpiredToken
+	}
+
+	return &claims, nil
+}
+
+func (k *key) sign(input []byte) []byte {
+	switch k.alg {
+	case AlgEdDSA:
+		return ed25519.Sign(k.private, input)
+	default:
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write(input)
+		return mac.Sum(nil)
+	}
+}
+
+func (k *key) verify(input, signature []byte) bool {
+	switch k.alg {
+	case AlgEdDSA:
+		return ed25519.Verify(k.public, input, signature)
+	default:
+		return subtle.ConstantTimeCompare(k.sign(input), signature) == 1
+	}
+}
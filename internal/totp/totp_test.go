@@ -0,0 +1,142 @@
+package totp
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+// the shared secret used by the RFC 4226 and RFC 6238 SHA-1 test vectors
+var rfcSecret = []byte("12345678901234567890")
+
+// RFC 4226 appendix D, the codes for counters 0 to 9
+func TestCodeHOTPVectors(t *testing.T) {
+	want := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+
+	for step, code := range want {
+		if got := Code(rfcSecret, int64(step)); got != code {
+			t.Errorf("Code(step %d) = %s, want %s", step, got, code)
+		}
+	}
+}
+
+// RFC 6238 appendix B for SHA-1, the vectors are 8 digits so only the last 6
+// are compared
+func TestCodeTOTPVectors(t *testing.T) {
+	tests := []struct {
+		unix int64
+		step int64
+		want string
+	}{
+		{59, 0x1, "287082"},
+		{1111111109, 0x23523EC, "081804"},
+		{1111111111, 0x23523ED, "050471"},
+		{1234567890, 0x273EF07, "005924"},
+		{2000000000, 0x3F940AA, "279037"},
+		{20000000000, 0x27BC86AA, "353130"},
+	}
+
+	for _, tt := range tests {
+		now := time.Unix(tt.unix, 0)
+
+		if got := Step(now); got != tt.step {
+			t.Errorf("Step(%d) = %#x, want %#x", tt.unix, got, tt.step)
+		}
+
+		if got := Code(rfcSecret, Step(now)); got != tt.want {
+			t.Errorf("Code at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+
+		step, ok := Validate(rfcSecret, tt.want, now)
+		if !ok || step != tt.step {
+			t.Errorf("Validate(%s) at %d = %#x, %t, want %#x, true", tt.want, tt.unix, step, ok, tt.step)
+		}
+	}
+}
+
+func TestValidateWindow(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current := Step(now)
+
+	tests := []struct {
+		name   string
+		step   int64
+		wantOK bool
+	}{
+		{"two steps behind", current - 2, false},
+		{"one step behind", current - 1, true},
+		{"current step", current, true},
+		{"one step ahead", current + 1, true},
+		{"two steps ahead", current + 2, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Validate(rfcSecret, Code(rfcSecret, tt.step), now)
+			if ok != tt.wantOK {
+				t.Fatalf("Validate() ok = %t, want %t", ok, tt.wantOK)
+			}
+
+			if ok && step != tt.step {
+				t.Errorf("Validate() step = %d, want %d", step, tt.step)
+			}
+		})
+	}
+}
+
+func TestValidateRejectsMalformedCodes(t *testing.T) {
+	now := time.Unix(59, 0)
+
+	tests := []struct {
+		name   string
+		secret []byte
+		code   string
+		wantOK bool
+	}{
+		{"valid", rfcSecret, "287082", true},
+		{"surrounding whitespace", rfcSecret, " 287082\n", true},
+		{"wrong code", rfcSecret, "287083", false},
+		{"too short", rfcSecret, "28708", false},
+		{"too long", rfcSecret, "2870820", false},
+		{"8 digit rfc code", rfcSecret, "94287082", false},
+		{"empty", rfcSecret, "", false},
+		{"other secret", []byte("09876543210987654321"), "287082", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := Validate(tt.secret, tt.code, now); ok != tt.wantOK {
+				t.Errorf("Validate(%q) ok = %t, want %t", tt.code, ok, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestProvisioningURI(t *testing.T) {
+	uri := ProvisioningURI("Movie DB", "alice@example.com", rfcSecret)
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/Movie DB:alice@example.com" {
+		t.Errorf("uri = %s, want an otpauth://totp/ uri labelled issuer:account", uri)
+	}
+
+	q := u.Query()
+
+	want := map[string]string{
+		"secret":    "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ",
+		"issuer":    "Movie DB",
+		"algorithm": "SHA1",
+		"digits":    "6",
+		"period":    "30",
+	}
+
+	for key, value := range want {
+		if got := q.Get(key); got != value {
+			t.Errorf("%s = %q, want %q", key, got, value)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package validator
 
 import (
+	"net/url"
 	"regexp"
 	"slices"
 )
@@ -60,3 +61,23 @@ func Unique[T comparable](values []T) bool {
 
 	return len(values) == len(uniqueValues)
 }
+
+// returns true if value is a lowercase ISO 639-1 language code i.e. "en"
+func LanguageCode(value string) bool {
+	return languageCodes[value]
+}
+
+// returns true if value is an uppercase ISO 3166-1 alpha-2 country code i.e. "US"
+func CountryCode(value string) bool {
+	return countryCodes[value]
+}
+
+// returns true if value is an absolute http or https URL with a host
+func URL(value string) bool {
+	u, err := url.Parse(value)
+	if err != nil {
+		return false
+	}
+
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}